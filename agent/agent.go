@@ -0,0 +1,320 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+	"github.com/locngoxuan/vulcan/runner"
+	"github.com/locngoxuan/vulcan/server"
+)
+
+// retryInterval is how long agent waits once server is unreachable.
+const retryInterval = 5 * time.Second
+
+var errNotRegistered = errors.New("agent is not registered")
+
+// Agent pulls jobs from a vulcan server and runs them on docker host of
+// Runner. Output of jobs is streamed back to server as they run.
+type Agent struct {
+	// Server is the base url of vulcan server, e.g. http://ci.local:8080.
+	Server   string
+	Token    string
+	Name     string
+	Labels   []string
+	Capacity int
+	// Runner holds docker host, toolchains and plugins used to run jobs.
+	Runner runner.Runner
+	// Executor runs jobs instead of docker host of Runner when it is not nil.
+	Executor runner.Executor
+	// Workspace is the directory where jobs are prepared.
+	Workspace string
+	// Keep leaves directories of jobs after running.
+	Keep   bool
+	Client *http.Client
+
+	id string
+}
+
+// Run registers agent and runs the jobs it is assigned until ctx is done,
+// then waits for running jobs.
+func (a *Agent) Run(ctx context.Context) error {
+	if a.Capacity < 1 {
+		return fmt.Errorf("capacity must be greater than 0")
+	}
+	if a.Client == nil {
+		a.Client = &http.Client{}
+	}
+	slots := make(chan struct{}, a.Capacity)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		err := a.register(ctx)
+		if err == nil {
+			log.Printf("Agent: registered to %s as %s", a.Server, a.id)
+			err = a.serve(ctx, slots, &wg)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == errNotRegistered {
+			continue
+		}
+		log.Printf("Agent: %v", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// serve polls jobs while a slot is free. Each job holds a slot until it is
+// finished.
+func (a *Agent) serve(ctx context.Context, slots chan struct{}, wg *sync.WaitGroup) error {
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		as, err := a.poll(ctx)
+		if err != nil || as == nil {
+			<-slots
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			a.runAssignment(ctx, *as)
+		}()
+	}
+}
+
+// statusError is returned when server answers with an error status.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func isNotFound(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusNotFound
+}
+
+// request sends a request to server and returns its response once its status
+// is successful.
+func (a *Agent) request(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(a.Server, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &statusError{
+			code: resp.StatusCode,
+			msg:  fmt.Sprintf(`%s %s: %s %s`, method, path, resp.Status, strings.TrimSpace(string(msg))),
+		}
+	}
+	return resp, nil
+}
+
+// do sends a request to server. The response is decoded into out when it is
+// not nil.
+func (a *Agent) do(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	resp, err := a.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (a *Agent) post(ctx context.Context, path string, in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return a.do(ctx, http.MethodPost, path, bytes.NewReader(data), out)
+}
+
+func (a *Agent) register(ctx context.Context) error {
+	var reg struct {
+		Id string `json:"id"`
+	}
+	err := a.post(ctx, "/agent/register", server.AgentInfo{
+		Name:     a.Name,
+		Labels:   a.Labels,
+		Capacity: a.Capacity,
+	}, &reg)
+	if err != nil {
+		return fmt.Errorf("failed to register: %v", err)
+	}
+	a.id = reg.Id
+	return nil
+}
+
+// poll waits for a job, it returns nil when none is assigned in time.
+func (a *Agent) poll(ctx context.Context) (*server.Assignment, error) {
+	var as *server.Assignment
+	err := a.do(ctx, http.MethodGet, "/agent/poll?agent="+a.id, nil, &as)
+	if isNotFound(err) {
+		return nil, errNotRegistered
+	}
+	return as, err
+}
+
+func (a *Agent) jobPath(as server.Assignment, name string) string {
+	return fmt.Sprintf("/agent/jobs/%s/%s", as.Id, name)
+}
+
+// runAssignment runs job then reports its result. Result is reported even if
+// ctx is done, so that server does not wait for a lost agent.
+func (a *Agent) runAssignment(ctx context.Context, as server.Assignment) {
+	log.Printf("Agent: running job %s of action %s", as.Job, as.Action)
+	rec := history.JobRun{Id: as.Job, Start: time.Now()}
+	err := a.execute(ctx, as, &rec)
+	rec.End = time.Now()
+	rec.Status = core.StatusSuccess
+	if err != nil {
+		rec.Status = core.StatusFailure
+		rec.Error = err.Error()
+	}
+	log.Printf("Agent: job %s of action %s is %s", as.Job, as.Action, rec.Status)
+
+	reportCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err = a.post(reportCtx, a.jobPath(as, "result"), rec, nil); err != nil {
+		log.Printf("Agent: failed to report result of job %s: %v", as.Job, err)
+	}
+}
+
+func (a *Agent) execute(ctx context.Context, as server.Assignment, rec *history.JobRun) error {
+	dir, err := ioutil.TempDir(a.Workspace, "vulcan-agent-")
+	if err != nil {
+		return fmt.Errorf("failed to create workspace: %v", err)
+	}
+	if !a.Keep {
+		defer os.RemoveAll(dir)
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go a.heartbeat(jobCtx, as, cancel)
+
+	//output of job is streamed in a single request lasting as long as job
+	pr, pw := io.Pipe()
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		err := a.do(context.Background(), http.MethodPost, a.jobPath(as, "log"), pr, nil)
+		_ = pr.CloseWithError(err)
+	}()
+	defer func() {
+		_ = pw.Close()
+		<-streamed
+	}()
+	l := log.New(pw, "", log.LstdFlags)
+
+	pwd := filepath.Join(dir, "project")
+	if err = a.download(jobCtx, as, pwd); err != nil {
+		return fmt.Errorf("failed to download workspace: %v", err)
+	}
+	configFile := filepath.Join(dir, as.Action+".yaml")
+	if err = ioutil.WriteFile(configFile, []byte(as.Config), 0644); err != nil {
+		return err
+	}
+	c, err := core.ReadProjectConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read project configuration file: %v", err)
+	}
+	if err = c.ExpandMatrix(); err != nil {
+		return fmt.Errorf("failed to read project configuration file: %v", err)
+	}
+	job, ok := c.Jobs[as.Job]
+	if !ok {
+		return fmt.Errorf("job %s not found", as.Job)
+	}
+	job.Id = as.Job
+
+	r := a.Runner
+	r.Pwd = pwd
+	if a.Executor != nil {
+		return a.Executor.ExecuteJob(jobCtx, l, &r, configFile, *job, as.Envs, rec)
+	}
+	return r.RunJob(jobCtx, l, configFile, *job, as.Envs, rec)
+}
+
+func (a *Agent) download(ctx context.Context, as server.Assignment, pwd string) error {
+	if err := os.MkdirAll(pwd, 0755); err != nil {
+		return err
+	}
+	resp, err := a.request(ctx, http.MethodGet, a.jobPath(as, "workspace"), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return server.ExtractArchive(resp.Body, pwd)
+}
+
+// heartbeat tells server job is running until ctx is done, and calls cancel
+// once server cancels job.
+func (a *Agent) heartbeat(ctx context.Context, as server.Assignment, cancel context.CancelFunc) {
+	ticker := time.NewTicker(server.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var hb server.Heartbeat
+		err := a.post(ctx, a.jobPath(as, "heartbeat"), nil, &hb)
+		if isNotFound(err) {
+			log.Printf("Agent: job %s of action %s is no longer known by server", as.Job, as.Action)
+			cancel()
+			return
+		}
+		if err != nil {
+			log.Printf("Agent: heartbeat of job %s failed: %v", as.Job, err)
+			continue
+		}
+		if hb.Cancelled {
+			log.Printf("Agent: job %s of action %s is cancelled", as.Job, as.Action)
+			cancel()
+			return
+		}
+	}
+}
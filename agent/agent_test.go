@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+	"github.com/locngoxuan/vulcan/runner"
+	"github.com/locngoxuan/vulcan/server"
+)
+
+// fakeExecutor stands in for docker host of an agent, it records jobs it runs
+// and fails the job named fail.
+type fakeExecutor struct {
+	name string
+	mu   *sync.Mutex
+	ran  map[string]string
+}
+
+func (e fakeExecutor) ExecuteJob(ctx context.Context, l *log.Logger, r *runner.Runner, configFile string, job core.JobConfig, envs []string, rec *history.JobRun) error {
+	if _, err := os.Stat(filepath.Join(r.Pwd, "source.txt")); err != nil {
+		return errors.New("workspace is not downloaded")
+	}
+	e.mu.Lock()
+	e.ran[job.Id] = e.name
+	e.mu.Unlock()
+	l.Printf("hello from %s", e.name)
+	if job.Id == "fail" {
+		rec.ExitCode = 2
+		return errors.New("exit code 2")
+	}
+	return nil
+}
+
+// syncBuffer collects output written by several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+const agentsConfig = `jobs:
+  build:
+    run-on: alpine
+    labels: [linux]
+    steps:
+      - run: make
+  gpu:
+    run-on: alpine
+    labels: [gpu]
+    steps:
+      - run: train
+  fail:
+    run-on: alpine
+    needs: [build, gpu]
+    if: always()
+    steps:
+      - run: exit 2
+`
+
+func TestAgentsRunJobsOfServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dispatcher := server.NewDispatcher(ctx, "agent-token")
+	srv := httptest.NewServer(dispatcher)
+	defer srv.Close()
+
+	var mu sync.Mutex
+	ran := make(map[string]string)
+	var wg sync.WaitGroup
+	agentCtx, stopAgents := context.WithCancel(ctx)
+	for _, a := range []*Agent{
+		{Name: "cpu", Labels: []string{"linux"}},
+		{Name: "gpu", Labels: []string{"linux", "gpu"}},
+	} {
+		a.Server = srv.URL
+		a.Token = "agent-token"
+		a.Capacity = 1
+		a.Workspace = t.TempDir()
+		a.Executor = fakeExecutor{name: a.Name, mu: &mu, ran: ran}
+		wg.Add(1)
+		go func(a *Agent) {
+			defer wg.Done()
+			if err := a.Run(agentCtx); err != nil {
+				t.Errorf("agent %s: %v", a.Name, err)
+			}
+		}(a)
+	}
+	defer func() {
+		stopAgents()
+		wg.Wait()
+	}()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "source.txt"), []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "ci.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(agentsConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := &syncBuffer{}
+	r := &runner.Runner{Pwd: dir, Parallel: 2, Executor: dispatcher, Output: out}
+	runCtx, cancelRun := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelRun()
+	err := r.RunConfigFile(runCtx, configFile, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "fail") {
+		t.Fatalf("run must fail because of job fail, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if ran["gpu"] != "gpu" {
+		t.Errorf("job gpu ran on %q, want agent gpu", ran["gpu"])
+	}
+	for _, job := range []string{"build", "gpu", "fail"} {
+		if _, ok := ran[job]; !ok {
+			t.Errorf("job %s did not run", job)
+		}
+	}
+	if !strings.Contains(out.String(), "[gpu] ") || !strings.Contains(out.String(), "hello from gpu") {
+		t.Errorf("output of agents is not streamed to server:\n%s", out.String())
+	}
+}
+
+func TestAgentWithWrongToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, token := range []string{"", "guess"} {
+		srv := httptest.NewServer(server.NewDispatcher(ctx, "agent-token"))
+		a := &Agent{Server: srv.URL, Token: token, Name: "eve", Capacity: 1}
+		a.Client = srv.Client()
+		err := a.register(ctx)
+		srv.Close()
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("token %q: agent must not register, got %v", token, err)
+		}
+	}
+}
@@ -2,12 +2,19 @@ package builtin
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/locngoxuan/vulcan/core"
 )
@@ -16,6 +23,7 @@ import (
 func RunVExec() error {
 	configFile := flag.String("config", "", "")
 	jobId := flag.String("job-id", "", "")
+	reportFile := flag.String("report", "", "")
 	flag.Parse()
 
 	if *configFile = strings.TrimSpace(*configFile); *configFile == "" {
@@ -30,9 +38,23 @@ func RunVExec() error {
 	if err != nil {
 		return fmt.Errorf(`failed to create temporary directory %v`, err)
 	}
+	removeOutputs, err := core.UseOwnOutputs()
+	if err != nil {
+		return fmt.Errorf(`failed to create output database %v`, err)
+	}
+	defer removeOutputs()
+
+	//vexec is PID 1 of container, whose signals have no default handler: once
+	//container is stopped, processes of the running step are killed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
 	fmt.Printf("Run job: config-file=%s id=%s\n", *configFile, *jobId)
-	config, err := core.ReadProjectConfig(*configFile)
+	config, err := core.LoadProjectConfig(*configFile, "")
+	if err != nil {
+		return err
+	}
+	err = config.ExpandMatrix()
 	if err != nil {
 		return err
 	}
@@ -44,7 +66,13 @@ func RunVExec() error {
 		if c.Args != nil {
 			c.Args.ReplaceEnv()
 		}
-		err = runJob(c)
+		var report core.JobReport
+		err = runJob(ctx, config.Env, c, &report)
+		if v := strings.TrimSpace(*reportFile); v != "" {
+			if werr := core.WriteJobReport(v, report); werr != nil {
+				fmt.Printf("failed to write report: %v\n", werr)
+			}
+		}
 		if err != nil {
 			return err
 		}
@@ -52,7 +80,10 @@ func RunVExec() error {
 	return nil
 }
 
-func runJob(c *core.JobConfig) error {
+// runJob runs steps of job and appends their results to report. Once ctx is
+// done, the job is cancelled and only steps whose condition holds, such as
+// always() or cancelled(), are run.
+func runJob(ctx context.Context, projectEnv core.EnvConfig, c *core.JobConfig, report *core.JobReport) error {
 	globalArgs := make(map[string]string)
 	if c.Args != nil {
 		for k, v := range *c.Args {
@@ -60,18 +91,23 @@ func runJob(c *core.JobConfig) error {
 		}
 	}
 
-	p := os.Getenv("PATH")
-	p = fmt.Sprintf(`%s:%s:%s`, p, core.ToolChainInsideContainer, core.PluginInsideContainer)
-	err := os.Setenv("PATH", p)
-	if err != nil {
-		return err
-	}
-	err = os.Setenv("GOTMPDIR", "/tmp")
-	if err != nil {
-		return err
-	}
-
-	for _, step := range c.Steps {
+	status := core.StatusSuccess
+	stepStatus := make(map[string]string)
+	var jobErr error
+	for i, step := range c.Steps {
+		if ctx.Err() != nil && status != core.StatusCancelled {
+			fmt.Printf("Job: %s (terminated)\n", c.Id)
+			status = core.StatusCancelled
+			if jobErr == nil {
+				jobErr = fmt.Errorf(`job is terminated`)
+			}
+		}
+		//once job is terminated, steps whose condition still holds run to
+		//clean up until container is killed
+		stepCtx := ctx
+		if status == core.StatusCancelled {
+			stepCtx = context.Background()
+		}
 		//build local arguments
 		args := make(map[string]string)
 		for k, v := range globalArgs {
@@ -83,72 +119,298 @@ func runJob(c *core.JobConfig) error {
 				args[k] = v
 			}
 		}
-		if v := strings.TrimSpace(step.Name); v != "" {
-			fmt.Printf("Step: %s\n", v)
+
+		name := stepName(step, i)
+		data := templateData(args, c.MatrixValues)
+		env, err := stepEnv(data, projectEnv, c.Env, step.Env)
+		if err != nil {
+			return fmt.Errorf(`step %s: %v`, name, err)
+		}
+		ok, err := core.EvalCondition(step.If, core.ExprContext{
+			Status: status,
+			Args:   args,
+			Matrix: c.MatrixValues,
+			Env:    env,
+			Steps:  stepStatus,
+		})
+		if err != nil {
+			return fmt.Errorf(`step %s: %v`, name, err)
 		}
+		rec := core.StepReport{
+			Id:     step.Id,
+			Name:   name,
+			Status: core.StatusSkipped,
+			Start:  time.Now(),
+		}
+		if !ok {
+			fmt.Printf("Step: %s (skipped)\n", name)
+			if step.Id != "" {
+				stepStatus[step.Id] = core.StatusSkipped
+			}
+			rec.End = rec.Start
+			report.Steps = append(report.Steps, rec)
+			continue
+		}
+		fmt.Printf("Step: %s\n", name)
 
+		if strings.TrimSpace(step.Shell) == "" {
+			step.Shell = c.Shell
+		}
+		err = runStepWithRetry(stepCtx, step, data, env, &rec)
+		rec.End = time.Now()
+		rec.ExitCode = exitCode(err)
+		rec.Status = core.StatusSuccess
+		if err != nil {
+			rec.Status = core.StatusFailure
+			rec.Error = err.Error()
+		}
+		report.Steps = append(report.Steps, rec)
+		if err != nil && step.ContinueOnError {
+			fmt.Printf("Step: %s (failed: %v, continue on error)\n", name, err)
+		} else if err != nil {
+			fmt.Printf("Step: %s (failed: %v)\n", name, err)
+			if status != core.StatusCancelled {
+				status = core.StatusFailure
+			}
+			if jobErr == nil {
+				jobErr = fmt.Errorf(`step %s: %v`, name, err)
+			}
+		}
 		if step.Id != "" {
-			err = core.SetCurrentStep(step.Id)
+			stepStatus[step.Id] = core.StatusSuccess
+			if err != nil {
+				stepStatus[step.Id] = core.StatusFailure
+			}
+
+			//load input from /tmp/vulcan/output/step-id
+			outputs, err := core.GetAllOutputs()
 			if err != nil {
 				return err
 			}
+			for _, outp := range outputs {
+				globalArgs[outp.Key] = outp.Value
+			}
+		}
+	}
+	return jobErr
+}
+
+func stepName(step core.StepConfig, index int) string {
+	if v := strings.TrimSpace(step.Name); v != "" {
+		return v
+	}
+	if v := strings.TrimSpace(step.Id); v != "" {
+		return v
+	}
+	return fmt.Sprintf(`#%d`, index+1)
+}
+
+// runStepWithRetry runs step until it succeeds, it runs out of attempts or
+// ctx is done. Every attempt is limited by timeout of step. Commands of the
+// last attempt are recorded in rec.
+func runStepWithRetry(ctx context.Context, step core.StepConfig, data map[string]interface{}, env map[string]string, rec *core.StepReport) error {
+	timeout, err := core.ParseDuration(step.Timeout)
+	if err != nil {
+		return fmt.Errorf(`invalid timeout: %v`, err)
+	}
+	attempts := 1
+	var backoff time.Duration
+	if step.Retry != nil {
+		if step.Retry.Attempts > 1 {
+			attempts = step.Retry.Attempts
+		}
+		backoff, err = core.ParseDuration(step.Retry.Backoff)
+		if err != nil {
+			return fmt.Errorf(`invalid retry backoff: %v`, err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		stepCtx, cancel := context.WithCancel(ctx)
+		if timeout > 0 {
+			stepCtx, cancel = context.WithTimeout(ctx, timeout)
 		}
+		rec.Attempts = attempt
+		rec.Commands = nil
+		err = runStep(stepCtx, step, data, env, rec)
+		timedOut := stepCtx.Err() == context.DeadlineExceeded
+		cancel()
+		if timedOut {
+			err = fmt.Errorf(`timed out after %s`, timeout)
+		} else if err != nil && ctx.Err() != nil {
+			err = fmt.Errorf(`terminated`)
+		}
+		if attempts > 1 || err != nil {
+			fmt.Printf("Attempt %d/%d: exit code %d\n", attempt, attempts, exitCode(err))
+		}
+		if err == nil || attempt >= attempts || ctx.Err() != nil {
+			return err
+		}
+		if backoff > 0 {
+			fmt.Printf("Retry in %s\n", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return err
+			}
+			backoff *= 2
+		}
+	}
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode()
+	}
+	return -1
+}
+
+func runStep(ctx context.Context, step core.StepConfig, data map[string]interface{}, env map[string]string, rec *core.StepReport) error {
+	if step.Id != "" {
+		err := core.SetCurrentStep(step.Id)
+		if err != nil {
+			return err
+		}
+	}
 
-		if v := strings.TrimSpace(step.Run); v != "" {
+	if v := strings.TrimSpace(step.Run); v != "" {
+		shell := strings.TrimSpace(step.Shell)
+		if shell == "" {
+			shell = core.DefaultShell
+		}
+		switch shell {
+		case core.ShellSh, core.ShellBash:
+			return runScript(ctx, shell, v, data, env, rec)
+		case core.ShellNone:
 			cmdlines := strings.Split(v, "\n")
 			for _, cmdLine := range cmdlines {
-				err = runCommandLine(cmdLine, args)
+				err := runCommandLine(ctx, cmdLine, data, env, rec)
 				if err != nil {
 					return err
 				}
 			}
-		} else if v := strings.TrimSpace(step.Use); v != "" {
-			//use plugin
-			var b strings.Builder
-			b.WriteString(v)
-			b.WriteString(" ")
-			if step.With != nil {
-				for k, v := range *step.With {
-					b.WriteString(fmt.Sprintf(`--%s`, k))
-					b.WriteString("=")
-					b.WriteString(v)
-					b.WriteString(" ")
-				}
-			}
-			cmdLine := b.String()
-			b.Reset()
-			err = runCommandLine(cmdLine, args)
-			if err != nil {
-				return err
+		default:
+			return fmt.Errorf(`shell %s is not supported`, shell)
+		}
+	} else if v := strings.TrimSpace(step.Use); v != "" {
+		//use plugin
+		var b strings.Builder
+		b.WriteString(v)
+		b.WriteString(" ")
+		if step.With != nil {
+			for k, v := range *step.With {
+				b.WriteString(fmt.Sprintf(`--%s`, k))
+				b.WriteString("=")
+				b.WriteString(v)
+				b.WriteString(" ")
 			}
-		} else {
-			//throw error
-			return fmt.Errorf(`either run or use must be specified`)
 		}
+		cmdLine := b.String()
+		b.Reset()
+		return runCommandLine(ctx, cmdLine, data, env, rec)
+	} else {
+		//throw error
+		return fmt.Errorf(`either run or use must be specified`)
+	}
+	return nil
+}
+
+// stepEnv returns environment variables of processes run by a step. They are
+// variables of vexec with toolchains and plugins added to PATH, overridden by
+// layers in order. Values of layers are rendered against data first.
+func stepEnv(data map[string]interface{}, layers ...core.EnvConfig) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	env["PATH"] = fmt.Sprintf(`%s:%s:%s`, env["PATH"], core.ToolChainInsideContainer, core.PluginInsideContainer)
+	env["GOTMPDIR"] = "/tmp"
 
-		if step.Id != "" {
-			//load input from /tmp/vulcan/output/step-id
-			outputs, err := core.GetAllOutputs()
+	for _, layer := range layers {
+		for k, v := range layer {
+			v, err := core.RenderTemplate(v, data)
 			if err != nil {
-				return err
-			}
-			for _, outp := range outputs {
-				globalArgs[outp.Key] = outp.Value
+				return nil, fmt.Errorf(`env %s: %v`, k, err)
 			}
+			env[k] = core.ReadEnvVariableIfHas(v)
 		}
 	}
-	return nil
+	return env, nil
+}
+
+func envList(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]string, len(keys))
+	for i, k := range keys {
+		list[i] = fmt.Sprintf("%s=%s", k, env[k])
+	}
+	return list
+}
+
+// lookPath searches for an executable named file in PATH of env rather than
+// PATH of vexec itself.
+func lookPath(file string, env map[string]string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	for _, dir := range filepath.SplitList(env["PATH"]) {
+		if dir == "" {
+			dir = "."
+		}
+		p := filepath.Join(dir, file)
+		fi, err := os.Stat(p)
+		if err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf(`executable file %s not found in PATH`, file)
+}
+
+func templateData(args map[string]string, matrix map[string]string) map[string]interface{} {
+	data := make(map[string]interface{}, len(args)+1)
+	for k, v := range args {
+		data[k] = v
+	}
+	data["matrix"] = matrix
+	return data
+}
+
+// maskArgs renders text as it is printed and recorded: args may hold secrets,
+// so their values are masked while those of matrix are kept.
+func maskArgs(text string, data map[string]interface{}) string {
+	masked := make(map[string]interface{}, len(data))
+	for k := range data {
+		masked[k] = "***"
+	}
+	masked["matrix"] = data["matrix"]
+	var buf bytes.Buffer
+	t, err := template.New("tmpl").Parse(text)
+	if err != nil || t.Execute(&buf, masked) != nil {
+		return text
+	}
+	return buf.String()
 }
 
-func runCommandLine(cmdLine string, args map[string]string) error {
+func runCommandLine(ctx context.Context, cmdLine string, data map[string]interface{}, env map[string]string, rec *core.StepReport) error {
 	cmdLine = strings.TrimSpace(cmdLine)
-	fmt.Printf("Run: %s\n", cmdLine)
+	shown := maskArgs(cmdLine, data)
+	fmt.Printf("Run: %s\n", shown)
+	rec.Commands = append(rec.Commands, shown)
 	var buf bytes.Buffer
 	t, err := template.New("tmpl").Parse(cmdLine)
 	if err != nil {
 		return err
 	}
-	err = t.Execute(&buf, args)
+	err = t.Execute(&buf, data)
 	if err != nil {
 		return err
 	}
@@ -169,9 +431,83 @@ func runCommandLine(cmdLine string, args map[string]string) error {
 	if argStart > len(cmdArgs) {
 		argStart = len(cmdArgs)
 	}
-	cmd := exec.Command(execFile, cmdArgs[argStart:]...)
-	cmd.Env = os.Environ()
+	execPath, err := lookPath(execFile, env)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(execPath, cmdArgs[argStart:]...)
+	cmd.Args[0] = execFile
+	cmd.Env = envList(env)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return runProcess(ctx, cmd)
+}
+
+// runScript writes rendered script to a temporary file then executes it with
+// shell, which stops at the first failed command.
+func runScript(ctx context.Context, shell, script string, data map[string]interface{}, env map[string]string, rec *core.StepReport) error {
+	shown := maskArgs(script, data)
+	rec.Commands = append(rec.Commands, shown)
+	for _, line := range strings.Split(shown, "\n") {
+		fmt.Printf("Run: %s\n", line)
+	}
+	var buf bytes.Buffer
+	t, err := template.New("tmpl").Parse(script)
+	if err != nil {
+		return err
+	}
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return err
+	}
+	script = buf.String()
+
+	f, err := ioutil.TempFile("", "vulcan-step-*.sh")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	_, err = f.WriteString(script + "\n")
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	if shell == core.ShellBash {
+		cmd = exec.Command("bash", "--noprofile", "--norc", "-e", "-o", "pipefail", f.Name())
+	} else {
+		cmd = exec.Command("sh", "-e", f.Name())
+	}
+	cmd.Env = envList(env)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return runProcess(ctx, cmd)
+}
+
+// runProcess runs cmd and kills its whole process tree once ctx is done.
+func runProcess(ctx context.Context, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		_ = killProcessTree(cmd)
+		<-done
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,293 @@
+package builtin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+)
+
+// captureStdout returns what f prints on standard output.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	_ = w.Close()
+	out, _ := ioutil.ReadAll(r)
+	return string(out)
+}
+
+func TestArgsAreMaskedInCommands(t *testing.T) {
+	const secret = "s3cr3t-t0ken"
+	dir := t.TempDir()
+	data := templateData(map[string]string{"token": secret, "dir": dir}, map[string]string{"os": "linux"})
+	env := map[string]string{"PATH": os.Getenv("PATH")}
+
+	tests := []struct {
+		name string
+		run  func(rec *core.StepReport) error
+		want string
+	}{
+		{"script", func(rec *core.StepReport) error {
+			return runScript(context.Background(), core.ShellSh, "echo {{.token}} > {{.dir}}/{{.matrix.os}}", data, env, rec)
+		}, "echo *** > ***/linux"},
+		{"command line", func(rec *core.StepReport) error {
+			return runCommandLine(context.Background(), "cp {{.dir}}/{{.matrix.os}} {{.dir}}/{{.token}}", data, env, rec)
+		}, "cp ***/linux ***/***"},
+	}
+	for _, tt := range tests {
+		var rec core.StepReport
+		var err error
+		out := captureStdout(t, func() {
+			err = tt.run(&rec)
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if strings.Contains(out, secret) {
+			t.Errorf("%s: secret is printed:\n%s", tt.name, out)
+		}
+		if !strings.Contains(out, "Run: "+tt.want+"\n") {
+			t.Errorf("%s: command is not printed:\n%s", tt.name, out)
+		}
+		if len(rec.Commands) != 1 || rec.Commands[0] != tt.want {
+			t.Errorf("%s: got commands %q, want %q", tt.name, rec.Commands, tt.want)
+		}
+	}
+
+	//commands still run with args rendered
+	copied, err := ioutil.ReadFile(filepath.Join(dir, secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(copied)) != secret {
+		t.Fatalf("got %q, want rendered token", copied)
+	}
+}
+
+func TestTerminatedJobStopsRunningStep(t *testing.T) {
+	dir := t.TempDir()
+	touch := func(name string) string { return "touch " + filepath.Join(dir, name) }
+	c := &core.JobConfig{
+		Id:    "build",
+		Shell: core.ShellSh,
+		Steps: []core.StepConfig{
+			{Run: "sleep 30 & wait"},
+			{Run: touch("after")},
+			{Run: touch("failed"), If: "failure()"},
+			{Run: touch("always"), If: "always()"},
+			{Run: touch("cancelled"), If: "cancelled()"},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+
+	var report core.JobReport
+	start := time.Now()
+	var err error
+	captureStdout(t, func() {
+		err = runJob(ctx, nil, c, &report)
+	})
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("step is still running %s after job is terminated", elapsed)
+	}
+	if err == nil {
+		t.Fatalf("terminated job must fail")
+	}
+	want := []string{core.StatusFailure, core.StatusSkipped, core.StatusSkipped, core.StatusSuccess, core.StatusSuccess}
+	if len(report.Steps) != len(want) {
+		t.Fatalf("got steps %+v, want %d", report.Steps, len(want))
+	}
+	for i, status := range want {
+		if report.Steps[i].Status != status {
+			t.Errorf("step #%d is %s, want %s", i+1, report.Steps[i].Status, status)
+		}
+	}
+	for name, ran := range map[string]bool{"after": false, "failed": false, "always": true, "cancelled": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != ran {
+			t.Errorf("step %s ran %v, want %v", name, err == nil, ran)
+		}
+	}
+}
+
+func TestRunBlockShell(t *testing.T) {
+	env := map[string]string{"PATH": os.Getenv("PATH")}
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{"", "Run: echo a | tr a b\na | tr a b\n"},
+		{core.ShellNone, "Run: echo a | tr a b\na | tr a b\n"},
+		{core.ShellSh, "Run: echo a | tr a b\nb\n"},
+		{core.ShellBash, "Run: echo a | tr a b\nb\n"},
+	}
+	for _, tt := range tests {
+		step := core.StepConfig{Run: "echo a | tr a b", Shell: tt.shell}
+		var err error
+		out := captureStdout(t, func() {
+			err = runStep(context.Background(), step, templateData(nil, nil), env, &core.StepReport{})
+		})
+		if err != nil {
+			t.Fatalf("shell %q: %v", tt.shell, err)
+		}
+		if out != tt.want {
+			t.Errorf("shell %q: got output %q, want %q", tt.shell, out, tt.want)
+		}
+	}
+}
+
+func TestRunStepWithRetry(t *testing.T) {
+	env := map[string]string{"PATH": os.Getenv("PATH")}
+	tests := []struct {
+		name     string
+		step     core.StepConfig
+		attempts int
+		wantErr  string
+		minTime  time.Duration
+	}{
+		{
+			name:     "succeeds at the last attempt",
+			step:     core.StepConfig{Run: "echo x >> {{.dir}}/count; test $(wc -l < {{.dir}}/count) -ge 3", Retry: &core.RetryConfig{Attempts: 3, Backoff: "50ms"}},
+			attempts: 3,
+			//backoff doubles after every attempt
+			minTime: 150 * time.Millisecond,
+		},
+		{
+			name:     "runs out of attempts",
+			step:     core.StepConfig{Run: "exit 3", Retry: &core.RetryConfig{Attempts: 2}},
+			attempts: 2,
+			wantErr:  "exit status 3",
+		},
+		{
+			name:     "without retry",
+			step:     core.StepConfig{Run: "exit 1"},
+			attempts: 1,
+			wantErr:  "exit status 1",
+		},
+		{
+			name:     "every attempt times out",
+			step:     core.StepConfig{Run: "sleep 5", Timeout: "200ms", Retry: &core.RetryConfig{Attempts: 2}},
+			attempts: 2,
+			wantErr:  "timed out after 200ms",
+		},
+		{
+			name:    "invalid backoff",
+			step:    core.StepConfig{Run: "true", Retry: &core.RetryConfig{Attempts: 2, Backoff: "soon"}},
+			wantErr: "invalid retry backoff",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.step.Shell = core.ShellSh
+			data := templateData(map[string]string{"dir": t.TempDir()}, nil)
+			var rec core.StepReport
+			var err error
+			start := time.Now()
+			captureStdout(t, func() {
+				err = runStepWithRetry(context.Background(), tt.step, data, env, &rec)
+			})
+			elapsed := time.Since(start)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if rec.Attempts != tt.attempts {
+				t.Errorf("got %d attempts, want %d", rec.Attempts, tt.attempts)
+			}
+			if elapsed < tt.minTime {
+				t.Errorf("retried after %s, want backoff of %s at least", elapsed, tt.minTime)
+			}
+		})
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	dir := t.TempDir()
+	c := &core.JobConfig{
+		Id:    "build",
+		Shell: core.ShellSh,
+		Steps: []core.StepConfig{
+			{Run: "exit 2", ContinueOnError: true},
+			{Run: "touch " + filepath.Join(dir, "a")},
+			{Run: "exit 3"},
+			{Run: "touch " + filepath.Join(dir, "b")},
+		},
+	}
+	var report core.JobReport
+	var err error
+	captureStdout(t, func() {
+		err = runJob(context.Background(), nil, c, &report)
+	})
+	if err == nil || err.Error() != "step #3: exit status 3" {
+		t.Fatalf("got error %v, want the one of step #3", err)
+	}
+	want := []string{core.StatusFailure, core.StatusSuccess, core.StatusFailure, core.StatusSkipped}
+	if len(report.Steps) != len(want) {
+		t.Fatalf("got steps %+v, want %d", report.Steps, len(want))
+	}
+	for i, status := range want {
+		if report.Steps[i].Status != status {
+			t.Errorf("step #%d is %s, want %s", i+1, report.Steps[i].Status, status)
+		}
+	}
+	if report.Steps[0].ExitCode != 2 {
+		t.Errorf("got exit code %d of step #1, want 2", report.Steps[0].ExitCode)
+	}
+}
+
+func TestStepEnv(t *testing.T) {
+	os.Setenv("VULCAN_TEST_SECRET", "s3cr3t")
+	os.Setenv("VULCAN_TEST_HOST", "host")
+	defer os.Unsetenv("VULCAN_TEST_SECRET")
+	defer os.Unsetenv("VULCAN_TEST_HOST")
+
+	data := templateData(map[string]string{"version": "1.2"}, map[string]string{"os": "linux"})
+	project := core.EnvConfig{"LEVEL": "project", "PROJECT": "p", "VERSION": "{{.version}}"}
+	job := core.EnvConfig{"LEVEL": "job", "JOB": "j", "TOKEN": "$VULCAN_TEST_SECRET"}
+	step := core.EnvConfig{"LEVEL": "step", "VULCAN_TEST_HOST": "step", "TARGET": "{{.matrix.os}}"}
+	env, err := stepEnv(data, project, job, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"LEVEL":            "step",
+		"PROJECT":          "p",
+		"JOB":              "j",
+		"VERSION":          "1.2",
+		"TOKEN":            "s3cr3t",
+		"VULCAN_TEST_HOST": "step",
+		"TARGET":           "linux",
+		"GOTMPDIR":         "/tmp",
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s is %q, want %q", k, env[k], v)
+		}
+	}
+	if !strings.HasSuffix(env["PATH"], ":"+core.ToolChainInsideContainer+":"+core.PluginInsideContainer) {
+		t.Errorf("toolchains and plugins are not in PATH %s", env["PATH"])
+	}
+
+	//variables of vexec are kept unless a layer overrides them
+	env, err = stepEnv(data, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["VULCAN_TEST_HOST"] != "host" || env["LEVEL"] != "project" {
+		t.Errorf("got VULCAN_TEST_HOST %q and LEVEL %q", env["VULCAN_TEST_HOST"], env["LEVEL"])
+	}
+
+	_, err = stepEnv(data, core.EnvConfig{"BROKEN": "{{.missing}}"})
+	if err == nil || !strings.HasPrefix(err.Error(), "env BROKEN: ") {
+		t.Errorf("got error %v, want the one of BROKEN", err)
+	}
+}
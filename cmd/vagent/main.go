@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/locngoxuan/vulcan/agent"
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+func main() {
+	hostname, _ := os.Hostname()
+	serverURL := flag.String("server", "", "specify url of vulcan server, e.g. http://ci.local:8080.")
+	token := flag.String("token", os.Getenv("VULCAN_AGENT_TOKEN"), "specify token of agents, default is VULCAN_AGENT_TOKEN.")
+	name := flag.String("name", hostname, "specify name of agent, default is host name.")
+	var labels core.StringList
+	flag.Var(&labels, "label", "advertise a label, jobs having labels only run on agents advertising all of them.")
+	capacity := flag.Int("capacity", 1, "specify maximum number of jobs running at the same time.")
+	workspace := flag.String("workspace", "", "specify directory where jobs are prepared, default is vulcan-agent in temporary directory.")
+	keep := flag.Bool("keep-workspace", false, "keep directories of jobs after running.")
+	configDocker := flag.String("config-docker", "", "specify location of docker configuration file.")
+	toolChains := flag.String("toolchain", "", "specify location of toolchains directory.")
+	plugins := flag.String("plugin", "", "specify location of plugins directory.")
+	verbose := flag.Bool("verbose", true, "stream output of containers to server.")
+	gracePeriod := flag.Duration("grace-period", runner.DefaultGracePeriod, "specify time given to containers to stop once jobs are cancelled.")
+	flag.Parse()
+
+	log.SetOutput(runner.Stderr)
+
+	if *serverURL = strings.TrimSpace(*serverURL); *serverURL == "" {
+		log.Fatalf("server is missing")
+	}
+	if *token == "" {
+		log.Fatalf("token is missing")
+	}
+	if *capacity < 1 {
+		log.Fatalf("capacity must be greater than 0")
+	}
+	if *name = strings.TrimSpace(*name); *name == "" {
+		log.Fatalf("name is missing")
+	}
+
+	var err error
+	if *workspace = strings.TrimSpace(*workspace); *workspace == "" {
+		*workspace = filepath.Join(os.TempDir(), "vulcan-agent")
+	}
+	err = os.MkdirAll(*workspace, 0755)
+	if err != nil {
+		log.Fatalf("failed to create workspace: %v", err)
+	}
+
+	a := &agent.Agent{
+		Server:    *serverURL,
+		Token:     *token,
+		Name:      *name,
+		Labels:    labels,
+		Capacity:  *capacity,
+		Workspace: *workspace,
+		Keep:      *keep,
+		Runner: runner.Runner{
+			Verbose:     *verbose,
+			GracePeriod: *gracePeriod,
+		},
+	}
+	if a.Runner.ToolChains, err = runner.HomeDir(*toolChains, "toolchains"); err != nil {
+		log.Fatalln(err)
+	}
+	if a.Runner.Plugins, err = runner.HomeDir(*plugins, "plugins"); err != nil {
+		log.Fatalln(err)
+	}
+	closeDocker, err := a.Runner.ConnectDocker(context.Background(), *configDocker)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer closeDocker()
+
+	ctx, kill, stop := runner.SignalContext()
+	defer stop()
+	a.Runner.Kill = kill
+	err = a.Run(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
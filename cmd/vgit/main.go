@@ -1,5 +1,210 @@
 package main
 
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/runner"
+	"github.com/locngoxuan/vulcan/scm"
+)
+
+// watcher polls a git repository and runs actions triggered by references
+// created or updated since the previous poll.
+type watcher struct {
+	repo      scm.Repository
+	stateFile string
+	runner    runner.TriggerRunner
+	known     map[string]string
+}
+
 func main() {
+	repo := flag.String("repo", ".", "specify location of git repository, bare or not.")
+	interval := flag.Duration("interval", 30*time.Second, "specify interval between polls of repository.")
+	workspace := flag.String("workspace", "", "specify directory where commits are checked out, default is vulcan-git in temporary directory.")
+	stateFile := flag.String("state", "", "specify file recording known references, default is refs.json in workspace.")
+	keep := flag.Bool("keep-workspace", false, "keep checked out commits after running.")
+	once := flag.Bool("once", false, "poll repository once then exit.")
+	dryRun := flag.Bool("dry-run", false, "print triggered actions instead of running them.")
+	envFile := flag.String("env-file", "", "specify location of environment file.")
+	configDocker := flag.String("config-docker", "", "specify location of docker configuration file.")
+	toolChains := flag.String("toolchain", "", "specify location of toolchains directory.")
+	plugins := flag.String("plugin", "", "specify location of plugins directory.")
+	templates := flag.String("template", "", "specify location of templates directory.")
+	verbose := flag.Bool("verbose", false, "print detail of build.")
+	parallel := flag.Int("parallel", 1, "specify maximum number of jobs running at the same time.")
+	failFast := flag.Bool("fail-fast", false, "cancel running jobs when a job fails.")
+	gracePeriod := flag.Duration("grace-period", runner.DefaultGracePeriod, "specify time given to containers to stop once runs are cancelled.")
+	var envs core.StringList
+	flag.Var(&envs, "env", "set environment variables")
+	flag.Parse()
+
+	log.SetOutput(runner.Stderr)
+
+	if *parallel < 1 {
+		log.Fatalf("parallel must be greater than 0")
+	}
+
+	repoPath, err := filepath.Abs(strings.TrimSpace(*repo))
+	if err != nil {
+		log.Fatalf("invalid repository: %v", err)
+	}
+	if *workspace = strings.TrimSpace(*workspace); *workspace == "" {
+		*workspace = filepath.Join(os.TempDir(), "vulcan-git")
+	}
+	*workspace, err = filepath.Abs(*workspace)
+	if err != nil {
+		log.Fatalf("invalid workspace: %v", err)
+	}
+	err = os.MkdirAll(*workspace, 0755)
+	if err != nil {
+		log.Fatalf("failed to create workspace: %v", err)
+	}
+	if *stateFile = strings.TrimSpace(*stateFile); *stateFile == "" {
+		*stateFile = filepath.Join(*workspace, "refs.json")
+	}
+
+	if *envFile = strings.TrimSpace(*envFile); *envFile != "" {
+		envFiles, err := core.UpdateEnvFromFile(*envFile)
+		if err != nil {
+			log.Fatalf("failed to update env variables: %v", err)
+		}
+		envs = append(envs, envFiles...)
+	}
+
+	w := &watcher{
+		repo:      scm.Repository{Path: repoPath},
+		stateFile: *stateFile,
+		runner: runner.TriggerRunner{
+			Runner: runner.Runner{
+				Verbose:     *verbose,
+				Templates:   runner.TemplateDir(*templates),
+				Parallel:    *parallel,
+				FailFast:    *failFast,
+				History:     runner.HistoryStore(),
+				GracePeriod: *gracePeriod,
+			},
+			Workspace: *workspace,
+			Keep:      *keep,
+			DryRun:    *dryRun,
+			Envs:      envs,
+		},
+	}
+	if !*dryRun {
+		if w.runner.Runner.ToolChains, err = runner.HomeDir(*toolChains, "toolchains"); err != nil {
+			log.Fatalln(err)
+		}
+		if w.runner.Runner.Plugins, err = runner.HomeDir(*plugins, "plugins"); err != nil {
+			log.Fatalln(err)
+		}
+		closeDocker, err := w.runner.Runner.ConnectDocker(context.Background(), *configDocker)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer closeDocker()
+	}
+
+	err = w.loadState()
+	if err != nil {
+		log.Fatalf("failed to read state: %v", err)
+	}
+
+	ctx, kill, stop := runner.SignalContext()
+	defer stop()
+	w.runner.Runner.Kill = kill
+
+	log.Printf("Repository: %s", repoPath)
+	for {
+		failed, err := w.poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("failed to poll repository: %v", err)
+		}
+		if *once {
+			if err != nil || failed > 0 {
+				stop()
+				os.Exit(1)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// poll runs actions triggered by changes of references since the previous
+// poll and returns the number of failed triggers. The first poll of a
+// repository only records its references.
+func (w *watcher) poll(ctx context.Context) (int, error) {
+	refs, err := w.repo.Refs()
+	if err != nil {
+		return 0, err
+	}
+	if w.known == nil {
+		log.Printf("Repository: watching %d reference(s)", len(refs))
+		w.known = refs
+		return 0, w.saveState()
+	}
+
+	failed := 0
+	for _, t := range scm.Changes(w.known, refs, w.repo.DefaultBranch()) {
+		if ctx.Err() != nil {
+			return failed, ctx.Err()
+		}
+		log.Printf("Trigger: %s", t)
+		err = w.runner.Run(ctx, w.repo, t)
+		if ctx.Err() != nil {
+			//an interrupted change is not known, so that it runs again
+			log.Printf("Trigger: %s is cancelled", t)
+			return failed, ctx.Err()
+		}
+		if err != nil {
+			failed++
+			log.Printf("Trigger: %s failed: %v", t, err)
+		}
+		w.known[t.Ref] = t.Commit
+		err = w.saveState()
+		if err != nil {
+			return failed, err
+		}
+	}
+	for ref := range w.known {
+		if _, ok := refs[ref]; !ok {
+			delete(w.known, ref)
+		}
+	}
+	return failed, w.saveState()
+}
+
+func (w *watcher) loadState() error {
+	data, err := ioutil.ReadFile(w.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &w.known)
+}
 
+func (w *watcher) saveState() error {
+	data, err := json.MarshalIndent(w.known, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := w.stateFile + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.stateFile)
 }
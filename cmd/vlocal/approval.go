@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+
+	"github.com/locngoxuan/vulcan/history"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+// promptApprover asks on standard input whether jobs are approved, one job at
+// a time. Standard input is only read once a job waits for approval.
+type promptApprover struct {
+	name  string
+	turn  chan struct{}
+	once  sync.Once
+	lines chan string
+}
+
+func newPromptApprover(name string) *promptApprover {
+	return &promptApprover{
+		name:  name,
+		turn:  make(chan struct{}, 1),
+		lines: make(chan string),
+	}
+}
+
+func (a *promptApprover) read() {
+	defer close(a.lines)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		a.lines <- scanner.Text()
+	}
+}
+
+// currentUser returns name of user running vlocal.
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+func (a *promptApprover) Approve(ctx context.Context, req runner.ApprovalRequest) (history.Approval, error) {
+	if !req.Allows(a.name) {
+		return history.Approval{
+			Comment: fmt.Sprintf("%s is not an approver of job %s", a.name, req.Job),
+		}, nil
+	}
+	select {
+	case a.turn <- struct{}{}:
+		defer func() { <-a.turn }()
+	case <-ctx.Done():
+		return history.Approval{}, ctx.Err()
+	}
+
+	a.once.Do(func() { go a.read() })
+	_, _ = fmt.Fprintf(runner.Stderr, "Approve job %s of action %s as %s before %s? [y/N] ",
+		req.Job, req.Action, a.name, req.Deadline.Format("2006-01-02 15:04"))
+	select {
+	case line, ok := <-a.lines:
+		if !ok {
+			return history.Approval{Comment: "no answer"}, nil
+		}
+		answer := strings.ToLower(strings.TrimSpace(line))
+		return history.Approval{
+			Approved: answer == "y" || answer == "yes",
+			By:       a.name,
+		}, nil
+	case <-ctx.Done():
+		_, _ = fmt.Fprintln(runner.Stderr)
+		return history.Approval{}, ctx.Err()
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+// maxCatchUp is how far back the daemon fires missed schedules, e.g. after
+// the machine was suspended.
+const maxCatchUp = 5 * time.Minute
+
+var onceAtLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// scheduledAction is an action of a project having on.schedule.
+type scheduledAction struct {
+	project       string
+	file          string
+	action        string
+	schedule      *core.CronSchedule
+	location      *time.Location
+	maxConcurrent int
+}
+
+func (a scheduledAction) String() string {
+	return fmt.Sprintf("%s:%s", a.project, a.action)
+}
+
+type daemon struct {
+	projects []string
+	runners  map[string]*runner.Runner
+	envs     []string
+	dryRun   bool
+
+	mu      sync.Mutex
+	running map[string]int
+	failed  int
+	wg      sync.WaitGroup
+}
+
+// runDaemon watches .vulcan directories of projects and runs actions at the
+// times of their on.schedule. It returns exit code of daemon command.
+func runDaemon(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	var projects core.StringList
+	fs.Var(&projects, "project", "specify directory of a project to schedule, default is present working directory.")
+	envFile := fs.String("env-file", "", "specify location of environment file.")
+	configDocker := fs.String("config-docker", "", "specify location of docker configuration file.")
+	toolChains := fs.String("toolchain", "", "specify location of toolchains directory.")
+	plugins := fs.String("plugin", "", "specify location of plugins directory.")
+	templates := fs.String("template", "", "specify location of templates directory.")
+	verbose := fs.Bool("verbose", false, "print detail of build.")
+	parallel := fs.Int("parallel", 1, "specify maximum number of jobs of an action running at the same time.")
+	failFast := fs.Bool("fail-fast", false, "cancel running jobs of an action when a job fails.")
+	gracePeriod := fs.Duration("grace-period", runner.DefaultGracePeriod, "specify time given to containers to stop once runs are cancelled.")
+	onceAt := fs.String("once-at", "", "fire schedules matching the given time once then exit, e.g. 2021-06-01T09:00.")
+	dryRun := fs.Bool("dry-run", false, "print actions instead of running them.")
+	var envs core.StringList
+	fs.Var(&envs, "env", "set environment variables")
+	_ = fs.Parse(args)
+
+	log.SetOutput(runner.Stderr)
+
+	if *parallel < 1 {
+		log.Printf("parallel must be greater than 0")
+		return 1
+	}
+
+	var at time.Time
+	if v := strings.TrimSpace(*onceAt); v != "" {
+		var err error
+		for _, layout := range onceAtLayouts {
+			at, err = time.ParseInLocation(layout, v, time.Local)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("invalid once-at %q, expected format is %s", v, onceAtLayouts[0])
+			return 1
+		}
+	}
+
+	if len(projects) == 0 {
+		projects = core.StringList{"."}
+	}
+	d := &daemon{
+		runners: make(map[string]*runner.Runner),
+		envs:    envs,
+		dryRun:  *dryRun,
+		running: make(map[string]int),
+	}
+	for _, p := range projects {
+		p, err := filepath.Abs(strings.TrimSpace(p))
+		if err != nil {
+			log.Printf("invalid project %s: %v", p, err)
+			return 1
+		}
+		d.projects = append(d.projects, p)
+	}
+
+	if *envFile = strings.TrimSpace(*envFile); *envFile != "" {
+		envFiles, err := core.UpdateEnvFromFile(*envFile)
+		if err != nil {
+			log.Printf("failed to update env variables: %v", err)
+			return 1
+		}
+		d.envs = append(d.envs, envFiles...)
+	}
+
+	ctx, kill, stop := runner.SignalContext()
+	defer stop()
+
+	base := runner.Runner{
+		Verbose:     *verbose,
+		Templates:   runner.TemplateDir(*templates),
+		Parallel:    *parallel,
+		FailFast:    *failFast,
+		History:     runner.HistoryStore(),
+		GracePeriod: *gracePeriod,
+		Kill:        kill,
+	}
+	if !d.dryRun {
+		var err error
+		if base.ToolChains, err = runner.HomeDir(*toolChains, "toolchains"); err != nil {
+			log.Println(err)
+			return 1
+		}
+		if base.Plugins, err = runner.HomeDir(*plugins, "plugins"); err != nil {
+			log.Println(err)
+			return 1
+		}
+		closeDocker, err := base.ConnectDocker(context.Background(), *configDocker)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		defer closeDocker()
+	}
+	for _, p := range d.projects {
+		r := base
+		r.Pwd = p
+		d.runners[p] = &r
+	}
+
+	if !at.IsZero() {
+		d.tick(ctx, at.Truncate(time.Minute))
+		d.wg.Wait()
+		if d.failed > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	now := time.Now()
+	for _, a := range d.scan() {
+		log.Printf("Schedule: %s next run at %s", a, a.schedule.Next(now.In(a.location)).Format(time.RFC3339))
+	}
+	last := now.Truncate(time.Minute)
+	for {
+		next := last.Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			log.Printf("Daemon: stopping, waiting for running actions")
+			d.wg.Wait()
+			return 0
+		case <-time.After(time.Until(next)):
+		}
+		now := time.Now().Truncate(time.Minute)
+		if now.Sub(next) > maxCatchUp {
+			log.Printf("Daemon: schedules between %s and %s are missed", next.Format(time.RFC3339), now.Add(-maxCatchUp).Format(time.RFC3339))
+			next = now.Add(-maxCatchUp)
+		}
+		for t := next; !t.After(now); t = t.Add(time.Minute) {
+			d.tick(ctx, t)
+		}
+		last = now
+	}
+}
+
+// scan reads configuration files of projects every time it is called, so
+// that changes of .vulcan directories are picked up without restarting.
+func (d *daemon) scan() []scheduledAction {
+	var actions []scheduledAction
+	for _, project := range d.projects {
+		files, err := runner.ListConfigFiles(filepath.Join(project, ".vulcan"), "")
+		if err != nil {
+			log.Printf("Schedule: failed to list file in .vulcan directory of %s: %v", project, err)
+			continue
+		}
+		for _, p := range files {
+			c, err := core.ReadProjectConfig(p)
+			if err != nil {
+				log.Printf("Schedule: %v", err)
+				continue
+			}
+			if c.OnConfig == nil || c.ScheduleConfig == nil {
+				continue
+			}
+			a := scheduledAction{
+				project:       project,
+				file:          p,
+				action:        strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)),
+				maxConcurrent: c.MaxConcurrent,
+			}
+			if a.maxConcurrent <= 0 {
+				a.maxConcurrent = 1
+			}
+			a.schedule, err = c.Schedule()
+			if err == nil {
+				a.location, err = c.Location()
+			}
+			if err != nil {
+				log.Printf("Schedule: %s: %v", a, err)
+				continue
+			}
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// tick fires actions scheduled at minute t.
+func (d *daemon) tick(ctx context.Context, t time.Time) {
+	for _, a := range d.scan() {
+		if a.schedule.Match(t.In(a.location)) {
+			d.fire(ctx, a, t)
+		}
+	}
+}
+
+func (d *daemon) fire(ctx context.Context, a scheduledAction, t time.Time) {
+	key := a.String()
+	d.mu.Lock()
+	if d.running[key] >= a.maxConcurrent {
+		d.mu.Unlock()
+		log.Printf("Schedule: %s skipped at %s, %d run(s) in progress", a, t.In(a.location).Format(time.RFC3339), a.maxConcurrent)
+		return
+	}
+	d.running[key]++
+	d.mu.Unlock()
+
+	log.Printf("Schedule: %s fired at %s", a, t.In(a.location).Format(time.RFC3339))
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		var err error
+		if !d.dryRun {
+			r := *d.runners[a.project]
+			r.Trigger = fmt.Sprintf("schedule %s", t.In(a.location).Format(time.RFC3339))
+			err = r.RunConfigFile(ctx, a.file, "", d.envs)
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.running[key]--
+		if err != nil {
+			d.failed++
+			log.Printf("Schedule: %s failed: %v", a, err)
+		} else if !d.dryRun {
+			log.Printf("Schedule: %s succeeded", a)
+		}
+	}()
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+func openHistory() (*history.Store, error) {
+	store := runner.HistoryStore()
+	if store == nil {
+		return nil, fmt.Errorf("VULCAN_HOME is not found")
+	}
+	return store, nil
+}
+
+// parseRunId parses the first argument of args left by fs as a run id.
+func parseRunId(fs *flag.FlagSet) (uint64, error) {
+	if fs.NArg() != 1 {
+		return 0, fmt.Errorf("run id is missing")
+	}
+	id, err := strconv.ParseUint(fs.Arg(0), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid run id %q", fs.Arg(0))
+	}
+	return id, nil
+}
+
+func duration(start, end time.Time) string {
+	if end.IsZero() || start.IsZero() {
+		return "-"
+	}
+	return end.Sub(start).Round(time.Second).String()
+}
+
+// runHistory lists recorded runs, or prints the details of a run when its id
+// is given. It returns exit code of history command.
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	action := fs.String("action", "", "list runs of action only.")
+	jobId := fs.String("job", "", "list runs of job only.")
+	status := fs.String("status", "", "list runs having status only, e.g. success or failure.")
+	project := fs.String("project", "", "list runs of project directory only.")
+	limit := fs.Int("limit", 20, "specify maximum number of runs listed, 0 is unlimited.")
+	_ = fs.Parse(args)
+
+	store, err := openHistory()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if fs.NArg() > 0 {
+		id, err := parseRunId(fs)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		run, err := store.Get(id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		printRun(run)
+		return 0
+	}
+
+	if *project != "" {
+		if *project, err = filepath.Abs(*project); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	runs, err := store.List(history.Filter{
+		Project: *project,
+		Action:  strings.TrimSpace(*action),
+		Job:     strings.TrimSpace(*jobId),
+		Status:  strings.TrimSpace(*status),
+		Limit:   *limit,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tACTION\tJOB\tTRIGGER\tSTATUS\tSTART\tDURATION")
+	for _, run := range runs {
+		job := run.Job
+		if job == "" {
+			job = "*"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", run.Id, run.Action, job, run.Trigger, run.Status,
+			run.Start.Local().Format("2006-01-02 15:04:05"), duration(run.Start, run.End))
+	}
+	_ = w.Flush()
+	return 0
+}
+
+func printRun(run history.Run) {
+	fmt.Printf("Run: %d\n", run.Id)
+	fmt.Printf("Project: %s\n", run.Project)
+	fmt.Printf("Action: %s\n", run.Action)
+	if run.Job != "" {
+		fmt.Printf("Job: %s\n", run.Job)
+	}
+	fmt.Printf("Trigger: %s\n", run.Trigger)
+	fmt.Printf("Status: %s\n", run.Status)
+	if run.Error != "" {
+		fmt.Printf("Error: %s\n", run.Error)
+	}
+	fmt.Printf("Start: %s\n", run.Start.Local().Format(time.RFC3339))
+	fmt.Printf("Duration: %s\n", duration(run.Start, run.End))
+	for _, job := range run.Jobs {
+		fmt.Printf("\nJob: %s (%s, exit code %d, %s)\n", job.Id, job.Status, job.ExitCode, duration(job.Start, job.End))
+		if job.Host != "" {
+			fmt.Printf("  Host: %s\n", job.Host)
+		}
+		if job.Digest != "" {
+			fmt.Printf("  Image: %s (%s)\n", job.Image, job.Digest)
+		}
+		if a := job.Approval; a != nil && a.Approved {
+			fmt.Printf("  Approved by %s at %s\n", a.By, a.Decided.Local().Format(time.RFC3339))
+		} else if a != nil && a.By != "" {
+			fmt.Printf("  Rejected by %s at %s\n", a.By, a.Decided.Local().Format(time.RFC3339))
+		}
+		if job.Error != "" {
+			fmt.Printf("  Error: %s\n", strings.TrimSpace(job.Error))
+		}
+		for _, step := range job.Steps {
+			fmt.Printf("  Step: %s (%s, exit code %d", step.Name, step.Status, step.ExitCode)
+			if step.Attempts > 1 {
+				fmt.Printf(", %d attempts", step.Attempts)
+			}
+			fmt.Printf(", %s)\n", duration(step.Start, step.End))
+			for _, cmd := range step.Commands {
+				for _, line := range strings.Split(strings.TrimRight(cmd, "\n"), "\n") {
+					fmt.Printf("    $ %s\n", line)
+				}
+			}
+		}
+	}
+}
+
+// runLogs prints captured output of jobs of a run. It returns exit code of
+// logs command.
+func runLogs(args []string) int {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	jobId := fs.String("job", "", "print log of job only.")
+	_ = fs.Parse(args)
+
+	store, err := openHistory()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	id, err := parseRunId(fs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	run, err := store.Get(id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	found := false
+	for _, job := range run.Jobs {
+		if *jobId != "" && job.Id != *jobId {
+			continue
+		}
+		found = true
+		data, err := store.Log(run.Id, job.Id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("=== Job: %s (%s) ===\n", job.Id, job.Status)
+		_, _ = os.Stdout.Write(data)
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "job %s not found in run %d\n", *jobId, run.Id)
+		return 1
+	}
+	return 0
+}
+
+// runReplay runs again the action of a recorded run with the configuration
+// it used. It returns exit code of replay command.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	project := fs.String("project", "", "specify project directory, default is the one of the run if it still exists, else present working directory.")
+	configDocker := fs.String("config-docker", "", "specify location of docker configuration file.")
+	toolChains := fs.String("toolchain", "", "specify location of toolchains directory.")
+	plugins := fs.String("plugin", "", "specify location of plugins directory.")
+	verbose := fs.Bool("verbose", false, "print detail of build.")
+	parallel := fs.Int("parallel", 1, "specify maximum number of jobs running at the same time.")
+	failFast := fs.Bool("fail-fast", false, "cancel running jobs when a job fails.")
+	gracePeriod := fs.Duration("grace-period", runner.DefaultGracePeriod, "specify time given to containers to stop once run is cancelled.")
+	approver := fs.String("approver", currentUser(), "specify name approving jobs requiring approval, default is current user.")
+	var envs, jobArgs core.StringList
+	fs.Var(&envs, "env", "set environment variables")
+	fs.Var(&jobArgs, "arg", "set args of jobs as key=value, args given to the run are not recorded.")
+	_ = fs.Parse(args)
+
+	store, err := openHistory()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	id, err := parseRunId(fs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	run, err := store.Get(id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *project = strings.TrimSpace(*project); *project == "" {
+		*project = "."
+		if st, err := os.Stat(run.Project); err == nil && st.IsDir() {
+			*project = run.Project
+		}
+	}
+	pwd, err := filepath.Abs(*project)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	dir, err := ioutil.TempDir("", "vulcan-replay-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, run.Action+".yaml")
+	err = ioutil.WriteFile(configFile, []byte(run.Config), 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, kill, stop := runner.SignalContext()
+	defer stop()
+
+	r := &runner.Runner{
+		Pwd:         pwd,
+		Verbose:     *verbose,
+		Parallel:    *parallel,
+		FailFast:    *failFast,
+		History:     store,
+		Trigger:     fmt.Sprintf("replay of %d", run.Id),
+		GracePeriod: *gracePeriod,
+		Kill:        kill,
+		Approver:    newPromptApprover(strings.TrimSpace(*approver)),
+	}
+	for _, kv := range jobArgs {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid arg %s, it must be key=value\n", kv)
+			return 1
+		}
+		if r.Args == nil {
+			r.Args = make(map[string]string)
+		}
+		r.Args[kv[:i]] = kv[i+1:]
+	}
+	if r.ToolChains, err = runner.HomeDir(*toolChains, "toolchains"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if r.Plugins, err = runner.HomeDir(*plugins, "plugins"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	closeDocker, err := r.ConnectDocker(context.Background(), *configDocker)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer closeDocker()
+
+	err = r.RunConfigFile(ctx, configFile, run.Job, append(run.TriggerEnv, envs...))
+	if errors.Is(err, runner.ErrCancelled) {
+		fmt.Fprintln(os.Stderr, err)
+		return runner.ExitCancelled
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -2,36 +2,54 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/runner"
 )
 
-var dockerCli core.DockerClient
-var pwd string
-var verbose bool
-var toolChains string
-var plugins string
-
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "daemon":
+			os.Exit(runDaemon(os.Args[2:]))
+		case "render":
+			os.Exit(runRender(os.Args[2:]))
+		case "history":
+			os.Exit(runHistory(os.Args[2:]))
+		case "logs":
+			os.Exit(runLogs(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		}
+	}
+
 	configDocker := flag.String("config-docker", "", "specify location of docker configuration file.")
 	envFile := flag.String("env-file", "", "specify location of environment file.")
 	action := flag.String("action", "", "specify action for running.")
 	jobId := flag.String("job", "", "specify job for running.")
-	flag.StringVar(&toolChains, "toolchain", "", "specify location of toolchains directory.")
-	flag.StringVar(&plugins, "plugin", "", "specify location of plugins directory.")
-	flag.BoolVar(&verbose, "verbose", false, "print detail of build.")
+	toolChains := flag.String("toolchain", "", "specify location of toolchains directory.")
+	plugins := flag.String("plugin", "", "specify location of plugins directory.")
+	templates := flag.String("template", "", "specify location of templates directory.")
+	verbose := flag.Bool("verbose", false, "print detail of build.")
+	parallel := flag.Int("parallel", 1, "specify maximum number of jobs running at the same time.")
+	failFast := flag.Bool("fail-fast", false, "cancel running jobs when a job fails.")
+	gracePeriod := flag.Duration("grace-period", runner.DefaultGracePeriod, "specify time given to containers to stop once run is cancelled.")
+	approver := flag.String("approver", currentUser(), "specify name approving jobs requiring approval, default is current user.")
 	var envs core.StringList
 	flag.Var(&envs, "env", "set environment variables")
 	flag.Parse()
 
+	log.SetOutput(runner.Stderr)
+
 	if *action = strings.TrimSpace(*action); *action == "" {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of: vulcan\n")
 		flag.PrintDefaults()
@@ -40,36 +58,22 @@ func main() {
 
 	*jobId = strings.TrimSpace(*jobId)
 
-	if toolChains = strings.TrimSpace(toolChains); toolChains == "" {
-		vucalHome := strings.TrimSpace(os.Getenv("VULCAN_HOME"))
-		if vucalHome == "" {
-			p, err := exec.LookPath("vlocal")
-			if err != nil {
-				log.Fatalln(err)
-			}
-			//return bin
-			vucalHome = filepath.Dir(filepath.Dir(p))
-		}
-		toolChains = filepath.Join(vucalHome, "toolchains")
+	if *parallel < 1 {
+		log.Fatalf("parallel must be greater than 0")
 	}
-	log.Printf("Toolchains directory: %s", toolChains)
-
-	if plugins = strings.TrimSpace(plugins); plugins == "" {
-		vucalHome := strings.TrimSpace(os.Getenv("VULCAN_HOME"))
-		if vucalHome == "" {
-			p, err := exec.LookPath("vlocal")
-			if err != nil {
-				log.Fatalln(err)
-			}
-			vucalHome = filepath.Dir(filepath.Dir(p))
-		}
-		plugins = filepath.Join(vucalHome, "plugins")
+
+	var err error
+	if *toolChains, err = runner.HomeDir(*toolChains, "toolchains"); err != nil {
+		log.Fatalln(err)
 	}
-	log.Printf("Plugins directory: %s", plugins)
+	log.Printf("Toolchains directory: %s", *toolChains)
 
-	if *configDocker = strings.TrimSpace(*configDocker); *configDocker != "" {
-		//read docker configuration
+	if *plugins, err = runner.HomeDir(*plugins, "plugins"); err != nil {
+		log.Fatalln(err)
 	}
+	log.Printf("Plugins directory: %s", *plugins)
+
+	*templates = runner.TemplateDir(*templates)
 
 	if *envFile = strings.TrimSpace(*envFile); *envFile != "" {
 		//read then export environment variables
@@ -82,64 +86,48 @@ func main() {
 		}
 	}
 
-	var err error
-	dockerCli, err = core.ConnectDockerHost(context.Background(),
-		[]string{core.DefaultDockerUnixSock, core.DefaultDockerTCPSock})
-	if err != nil {
-		log.Fatalf("failed to connect docker host: %v", err)
-	}
-
-	pwd, err = filepath.Abs(".")
+	pwd, err := filepath.Abs(".")
 	if err != nil {
 		log.Fatalf("failed to get present working directory: %v", err)
 	}
 
-	vulCanDir := filepath.Join(pwd, ".vulcan")
-	st, err := os.Stat(vulCanDir)
-	if err != nil {
-		log.Fatalf("failed to read .vulcan directory: %v", err)
+	ctx, kill, stop := runner.SignalContext()
+	defer stop()
+
+	r := &runner.Runner{
+		Pwd:         pwd,
+		Verbose:     *verbose,
+		ToolChains:  *toolChains,
+		Plugins:     *plugins,
+		Templates:   *templates,
+		Parallel:    *parallel,
+		FailFast:    *failFast,
+		History:     runner.HistoryStore(),
+		GracePeriod: *gracePeriod,
+		Kill:        kill,
+		Approver:    newPromptApprover(strings.TrimSpace(*approver)),
 	}
-
-	if !st.IsDir() {
-		log.Fatalf("%s is not directory", vulCanDir)
+	closeDocker, err := r.ConnectDocker(context.Background(), *configDocker)
+	if err != nil {
+		log.Fatalln(err)
 	}
+	defer closeDocker()
 
-	fileInfos, err := ioutil.ReadDir(vulCanDir)
+	files, err := runner.ListConfigFiles(filepath.Join(pwd, ".vulcan"), *action)
 	if err != nil {
 		log.Fatalf("failed to list file in .vulcan directory: %v", err)
 	}
 
-	for _, fileInfo := range fileInfos {
-		p := filepath.Join(vulCanDir, fileInfo.Name())
-		ext := filepath.Ext(p)
-		//exclude not yaml file
-		if ext != ".yaml" && ext != ".yml" {
-			continue
-		}
-		fileName := strings.TrimSuffix(fileInfo.Name(), ext)
-		if fileName == *action {
-			c, err := core.ReadProjectConfig(p)
-			if err != nil {
-				log.Fatalf("failed to read project configuration file: %v", err)
-			}
-			for id, job := range c.Jobs {
-				job.Id = strings.TrimSpace(id)
-				//run job
-				if *jobId == "" || *jobId == job.Id {
-					err = runJob(fileInfo.Name(), *job, envs)
-					if err != nil {
-						if verbose {
-							log.Printf("failed to run job: %s", job.Name)
-						} else {
-							log.Printf("failed to run job: %s", job.Name)
-							log.Println("=== BEGIN: Error Message ===")
-							log.Printf("%v", err)
-							log.Println("=== END: Error Message ===")
-						}
-						os.Exit(1)
-					}
-				}
+	for _, p := range files {
+		err = r.RunConfigFile(ctx, p, *jobId, envs)
+		if err != nil {
+			log.Printf("%v", err)
+			closeDocker()
+			stop()
+			if errors.Is(err, runner.ErrCancelled) {
+				os.Exit(runner.ExitCancelled)
 			}
+			os.Exit(1)
 		}
 	}
 }
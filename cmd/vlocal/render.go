@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+// runRender prints configuration files in .vulcan directory after resolving
+// templates extended by jobs, and returns exit code of render command.
+func runRender(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	action := fs.String("action", "", "specify action for rendering, empty is all actions.")
+	jobId := fs.String("job", "", "specify job for rendering, empty is all jobs.")
+	templates := fs.String("template", "", "specify location of templates directory.")
+	_ = fs.Parse(args)
+
+	pwd, err := filepath.Abs(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get present working directory: %v\n", err)
+		return 1
+	}
+
+	files, err := runner.ListConfigFiles(filepath.Join(pwd, ".vulcan"), strings.TrimSpace(*action))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list file in .vulcan directory: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "no configuration file found")
+		return 1
+	}
+
+	*jobId = strings.TrimSpace(*jobId)
+	dir := runner.TemplateDir(*templates)
+	found := false
+	for _, p := range files {
+		c, err := core.LoadProjectConfig(p, dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", p, err)
+			return 1
+		}
+		if *jobId != "" {
+			job, ok := c.Jobs[*jobId]
+			if !ok {
+				continue
+			}
+			c.Jobs = map[string]*core.JobConfig{*jobId: job}
+		}
+
+		data, err := c.Render()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", p, err)
+			return 1
+		}
+		if rel, err := filepath.Rel(pwd, p); err == nil {
+			p = rel
+		}
+		if found {
+			fmt.Println("---")
+		}
+		found = true
+		fmt.Printf("# %s\n%s", p, data)
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "job %s not found\n", *jobId)
+		return 1
+	}
+	return 0
+}
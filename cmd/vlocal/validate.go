@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+// runValidate checks configuration files given as arguments, or those in
+// .vulcan directory when there is none, and returns exit code of validate
+// command.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	action := fs.String("action", "", "specify action for validating, empty is all actions.")
+	templates := fs.String("template", "", "specify location of templates directory.")
+	_ = fs.Parse(args)
+
+	pwd, err := filepath.Abs(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get present working directory: %v\n", err)
+		return 1
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files, err = runner.ListConfigFiles(filepath.Join(pwd, ".vulcan"), strings.TrimSpace(*action))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list file in .vulcan directory: %v\n", err)
+			return 1
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "no configuration file found")
+		return 1
+	}
+
+	problems, invalid := 0, 0
+	for _, p := range files {
+		if rel, err := filepath.Rel(pwd, p); err == nil && filepath.IsAbs(p) && !strings.HasPrefix(rel, "..") {
+			p = rel
+		}
+		diagnostics, err := core.ValidateProjectConfig(p, runner.TemplateDir(*templates))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", p, err)
+			problems++
+			invalid++
+			continue
+		}
+		for _, d := range diagnostics {
+			fmt.Println(d.String())
+		}
+		problems += len(diagnostics)
+		if len(diagnostics) > 0 {
+			invalid++
+		}
+	}
+
+	if problems > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found in %d of %d file(s)\n", problems, invalid, len(files))
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%d file(s) are valid\n", len(files))
+	return 0
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/runner"
+	"github.com/locngoxuan/vulcan/scm"
+	"github.com/locngoxuan/vulcan/server"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "specify address to listen on.")
+	secret := flag.String("secret", os.Getenv("VULCAN_WEBHOOK_SECRET"), "specify secret of webhooks, default is VULCAN_WEBHOOK_SECRET.")
+	insecureWebhook := flag.Bool("insecure-webhook", false, "accept webhooks without secret, anyone reaching server may then trigger runs.")
+	var repos core.StringList
+	flag.Var(&repos, "repo", "register a repository as name=location, e.g. owner/project=/srv/git/project.git. Webhooks are only served for registered repositories.")
+	var projects core.StringList
+	flag.Var(&projects, "project", "register a project directory runnable by the API as name=directory.")
+	apiToken := flag.String("api-token", os.Getenv("VULCAN_API_TOKEN"), "specify token of API, default is VULCAN_API_TOKEN. Without it, runs and dashboard are not served.")
+	approverTokens := flag.String("approver-tokens", "", "specify file of approvers and their token, one name=token per line.")
+	workspace := flag.String("workspace", "", "specify directory where commits are checked out, default is vulcan-server in temporary directory.")
+	queueSize := flag.Int("queue-size", 100, "specify maximum number of events waiting to run.")
+	workers := flag.Int("workers", 1, "specify number of events running at the same time.")
+	keep := flag.Bool("keep-workspace", false, "keep checked out commits after running.")
+	dryRun := flag.Bool("dry-run", false, "print triggered actions instead of running them.")
+	agents := flag.Bool("agents", false, "run jobs on build agents registered to server instead of local docker host.")
+	agentToken := flag.String("agent-token", os.Getenv("VULCAN_AGENT_TOKEN"), "specify token of agents, default is VULCAN_AGENT_TOKEN.")
+	envFile := flag.String("env-file", "", "specify location of environment file.")
+	configDocker := flag.String("config-docker", "", "specify location of docker configuration file.")
+	toolChains := flag.String("toolchain", "", "specify location of toolchains directory.")
+	plugins := flag.String("plugin", "", "specify location of plugins directory.")
+	templates := flag.String("template", "", "specify location of templates directory.")
+	verbose := flag.Bool("verbose", false, "print detail of build.")
+	parallel := flag.Int("parallel", 1, "specify maximum number of jobs running at the same time.")
+	failFast := flag.Bool("fail-fast", false, "cancel running jobs when a job fails.")
+	gracePeriod := flag.Duration("grace-period", runner.DefaultGracePeriod, "specify time given to containers to stop once runs are cancelled.")
+	var envs core.StringList
+	flag.Var(&envs, "env", "set environment variables")
+	flag.Parse()
+
+	log.SetOutput(runner.Stderr)
+
+	if *parallel < 1 || *workers < 1 || *queueSize < 1 {
+		log.Fatalf("parallel, workers and queue-size must be greater than 0")
+	}
+	if *approverTokens != "" && *apiToken == "" {
+		log.Fatalf("approver-tokens requires api-token")
+	}
+	if *agents && *agentToken == "" {
+		log.Fatalf("agents requires agent-token")
+	}
+
+	locations := make(map[string]string)
+	for _, repo := range repos {
+		i := strings.Index(repo, "=")
+		if i <= 0 {
+			log.Fatalf("invalid repo %q, expected format is name=location", repo)
+		}
+		locations[strings.TrimSpace(repo[:i])] = strings.TrimSpace(repo[i+1:])
+	}
+
+	if len(locations) > 0 && *secret == "" {
+		if !*insecureWebhook {
+			log.Fatalf("secret of webhooks is missing, set --secret or --insecure-webhook")
+		}
+		log.Printf("Webhook: no secret, payloads are not verified")
+	}
+
+	projectDirs := make(map[string]string)
+	for _, project := range projects {
+		i := strings.Index(project, "=")
+		if i <= 0 {
+			log.Fatalf("invalid project %q, expected format is name=directory", project)
+		}
+		dir, err := filepath.Abs(strings.TrimSpace(project[i+1:]))
+		if err != nil {
+			log.Fatalf("invalid project %q: %v", project, err)
+		}
+		projectDirs[strings.TrimSpace(project[:i])] = dir
+	}
+
+	var err error
+	if *workspace = strings.TrimSpace(*workspace); *workspace == "" {
+		*workspace = filepath.Join(os.TempDir(), "vulcan-server")
+	}
+	err = os.MkdirAll(*workspace, 0755)
+	if err != nil {
+		log.Fatalf("failed to create workspace: %v", err)
+	}
+
+	if *envFile = strings.TrimSpace(*envFile); *envFile != "" {
+		envFiles, err := core.UpdateEnvFromFile(*envFile)
+		if err != nil {
+			log.Fatalf("failed to update env variables: %v", err)
+		}
+		envs = append(envs, envFiles...)
+	}
+
+	tr := &runner.TriggerRunner{
+		Runner: runner.Runner{
+			Verbose:     *verbose,
+			Templates:   runner.TemplateDir(*templates),
+			Parallel:    *parallel,
+			FailFast:    *failFast,
+			History:     runner.HistoryStore(),
+			GracePeriod: *gracePeriod,
+			Locker:      server.NewGroups(),
+		},
+		Workspace: *workspace,
+		Keep:      *keep,
+		DryRun:    *dryRun,
+		Envs:      envs,
+	}
+	ctx, kill, stop := runner.SignalContext()
+	defer stop()
+	tr.Runner.Kill = kill
+
+	mux := http.NewServeMux()
+	if *agents {
+		dispatcher := server.NewDispatcher(ctx, *agentToken)
+		tr.Runner.Executor = dispatcher
+		mux.Handle("/agent/", dispatcher)
+	} else if !*dryRun {
+		if tr.Runner.ToolChains, err = runner.HomeDir(*toolChains, "toolchains"); err != nil {
+			log.Fatalln(err)
+		}
+		if tr.Runner.Plugins, err = runner.HomeDir(*plugins, "plugins"); err != nil {
+			log.Fatalln(err)
+		}
+		closeDocker, err := tr.Runner.ConnectDocker(context.Background(), *configDocker)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer closeDocker()
+	}
+
+	queue := server.NewQueue(ctx, *queueSize, *workers, func(ctx context.Context, e server.Event) {
+		log.Printf("Trigger: %s", e)
+		err := tr.Run(ctx, scm.Repository{Path: e.CloneURL}, e.Trigger)
+		if err != nil {
+			log.Printf("Trigger: %s failed: %v", e, err)
+		}
+	})
+
+	//clone url of payload is never fetched, so that a payload cannot make
+	//server run jobs of any repository
+	if len(locations) > 0 {
+		mux.Handle("/webhook", &server.WebhookHandler{
+			Secret:   *secret,
+			Insecure: *insecureWebhook,
+			Enqueue: func(e server.Event) error {
+				location, ok := locations[e.Repository]
+				if !ok {
+					return fmt.Errorf("repository %s is not registered", e.Repository)
+				}
+				e.CloneURL = location
+				return queue.Enqueue(e)
+			},
+		})
+	} else {
+		log.Printf("Webhook: no repository is registered, webhooks are not served")
+	}
+	if *apiToken == "" {
+		log.Printf("API: no token, runs are not served")
+	} else if tr.Runner.History != nil {
+		api := server.NewAPI(ctx, *apiToken, projectDirs, tr.Runner, envs)
+		if *approverTokens = strings.TrimSpace(*approverTokens); *approverTokens != "" {
+			api.Approvers, err = server.ReadApproverTokens(*approverTokens)
+			if err != nil {
+				log.Fatalf("failed to read approver tokens: %v", err)
+			}
+		}
+		tr.Runner.Approver = api
+		mux.Handle("/runs", api)
+		mux.Handle("/runs/", api)
+		mux.Handle("/projects", api)
+		mux.Handle("/", server.Dashboard())
+	} else {
+		log.Printf("API: VULCAN_HOME is not found, runs are not served")
+	}
+	srv := &http.Server{Addr: *listen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	if len(locations) > 0 {
+		log.Printf("Webhook: listening on %s/webhook", *listen)
+	}
+	if *apiToken != "" && tr.Runner.History != nil {
+		log.Printf("Dashboard: listening on %s/", *listen)
+	}
+	err = srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("failed to serve: %v", err)
+	}
+	queue.Close()
+}
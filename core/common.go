@@ -4,3 +4,5 @@ import "path/filepath"
 
 var ToolChainInsideContainer = filepath.Join("/etc", "vulcan", "toolchains")
 var PluginInsideContainer = filepath.Join("/etc", "vulcan", "plugins")
+var ConfigInsideContainer = filepath.Join("/etc", "vulcan", "config")
+var ReportInsideContainer = filepath.Join("/etc", "vulcan", "report")
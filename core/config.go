@@ -4,18 +4,25 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type ProjectConfig struct {
-	Name      string `yaml:"name,omitempty"`
-	*OnConfig `yaml:"on,omitempty"`
-	Jobs      map[string]*JobConfig `yaml:"jobs,omitempty"`
+	Name        string `yaml:"name,omitempty"`
+	*OnConfig   `yaml:"on,omitempty"`
+	Include     []string              `yaml:"include,omitempty"`
+	Concurrency *ConcurrencyConfig    `yaml:"concurrency,omitempty"`
+	Env         EnvConfig             `yaml:"env,omitempty"`
+	Jobs        map[string]*JobConfig `yaml:"jobs,omitempty"`
 }
 
 type OnConfig struct {
 	Branch          []string `yaml:"branch,omitempty"`
+	Tag             []string `yaml:"tag,omitempty"`
 	Event           []string `yaml:"event,omitempty"`
 	*ScheduleConfig `yaml:"schedule,omitempty"`
 }
@@ -23,6 +30,10 @@ type OnConfig struct {
 type ScheduleConfig struct {
 	Cron     string `yaml:"cron,omitempty"`
 	Repeated string `yaml:"repeat,omitempty"`
+	Timezone string `yaml:"timezone,omitempty"`
+	// MaxConcurrent is the number of runs of the action allowed at the same
+	// time, scheduled runs are skipped once it is reached. Default is 1.
+	MaxConcurrent int `yaml:"max-concurrent,omitempty"`
 }
 
 const (
@@ -32,6 +43,17 @@ const (
 
 type ArgsConfig map[string]string
 
+func (a *ArgsConfig) clone() *ArgsConfig {
+	if a == nil {
+		return nil
+	}
+	n := make(ArgsConfig, len(*a))
+	for k, v := range *a {
+		n[k] = v
+	}
+	return &n
+}
+
 func (a ArgsConfig) ReplaceEnv() error {
 	for k, v := range a {
 		a[k] = ReadEnvVariableIfHas(v)
@@ -40,25 +62,223 @@ func (a ArgsConfig) ReplaceEnv() error {
 }
 
 type JobConfig struct {
-	Id        string       `yaml:"-"`
-	Name      string       `yaml:"name,omitempty"`
-	RunOn     string       `yaml:"run-on,omitempty"`
-	BaseDir   string       `yaml:"base-dir,omitempty"`
-	OS        string       `yaml:"os,omitempty"`
-	Arch      string       `yaml:"arch,omitempty"`
-	Artifacts []string     `yaml:"artifacts,omitempty"`
-	Hosts     []string     `yaml:"hosts,omitempty"`
-	Args      *ArgsConfig  `yaml:"args,omitempty"`
-	Steps     []StepConfig `yaml:"steps,omitempty"`
+	Id           string                    `yaml:"-"`
+	MatrixValues map[string]string         `yaml:"-"`
+	Extends      string                    `yaml:"extends,omitempty"`
+	Name         string                    `yaml:"name,omitempty"`
+	RunOn        string                    `yaml:"run-on,omitempty"`
+	Pull         string                    `yaml:"pull,omitempty"`
+	BaseDir      string                    `yaml:"base-dir,omitempty"`
+	OS           string                    `yaml:"os,omitempty"`
+	Arch         string                    `yaml:"arch,omitempty"`
+	Artifacts    []string                  `yaml:"artifacts,omitempty"`
+	Hosts        []string                  `yaml:"hosts,omitempty"`
+	Needs        []string                  `yaml:"needs,omitempty"`
+	Labels       []string                  `yaml:"labels,omitempty"`
+	If           string                    `yaml:"if,omitempty"`
+	Timeout      string                    `yaml:"timeout,omitempty"`
+	Shell        string                    `yaml:"shell,omitempty"`
+	Approval     *ApprovalConfig           `yaml:"approval,omitempty"`
+	Concurrency  *ConcurrencyConfig        `yaml:"concurrency,omitempty"`
+	Env          EnvConfig                 `yaml:"env,omitempty"`
+	Services     map[string]*ServiceConfig `yaml:"services,omitempty"`
+	Matrix       *MatrixConfig             `yaml:"matrix,omitempty"`
+	Args         *ArgsConfig               `yaml:"args,omitempty"`
+	Steps        []StepConfig              `yaml:"steps,omitempty"`
+	// keys are the keys set in yaml, nil when job is not read from yaml.
+	keys map[string]bool
+}
+
+// Pull policies of images of a job and its services. Images may be pinned by
+// digest, e.g. alpine@sha256:...
+const (
+	PullAlways       = "always"
+	PullIfNotPresent = "if-not-present"
+	PullNever        = "never"
+	DefaultPull      = PullIfNotPresent
+)
+
+// ApprovalConfig pauses a run before its job until someone approves it. The
+// job is rejected once timeout, DefaultApprovalTimeout when it is empty, runs
+// out.
+type ApprovalConfig struct {
+	Required bool `yaml:"required,omitempty"`
+	// Approvers are the names allowed to approve, anyone may when it is
+	// empty.
+	Approvers []string `yaml:"approvers,omitempty"`
+	Timeout   string   `yaml:"timeout,omitempty"`
+}
+
+// DefaultApprovalTimeout is the time a job waits for approval by default.
+const DefaultApprovalTimeout = 24 * time.Hour
+
+// Allows tells whether name may approve.
+func (a *ApprovalConfig) Allows(name string) bool {
+	if len(a.Approvers) == 0 {
+		return true
+	}
+	for _, approver := range a.Approvers {
+		if strings.TrimSpace(approver) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ConcurrencyConfig serializes runs, or jobs, of the same group. Group is a
+// template of args, matrix and ConcurrencyVars, e.g. deploy-{{.branch}}.
+type ConcurrencyConfig struct {
+	Group string `yaml:"group,omitempty"`
+	// CancelInProgress cancels the run or job of group in progress instead
+	// of waiting for it.
+	CancelInProgress bool `yaml:"cancel-in-progress,omitempty"`
+}
+
+// ConcurrencyVars are the values describing a run that concurrency groups
+// may refer to, besides args and matrix of jobs.
+var ConcurrencyVars = []string{"action", "job", "event", "ref", "commit", "branch", "tag", "pull_request"}
+
+// ServiceConfig is a container started next to the job container, such as a
+// database. The job reaches it by the name of the service.
+type ServiceConfig struct {
+	Image       string             `yaml:"image,omitempty"`
+	Env         EnvConfig          `yaml:"env,omitempty"`
+	Ports       []string           `yaml:"ports,omitempty"`
+	Healthcheck *HealthcheckConfig `yaml:"healthcheck,omitempty"`
+}
+
+// HealthcheckConfig is a command run inside a service container to tell
+// whether the service is ready.
+type HealthcheckConfig struct {
+	Command  string `yaml:"command,omitempty"`
+	Interval string `yaml:"interval,omitempty"`
+	Timeout  string `yaml:"timeout,omitempty"`
+	Retries  int    `yaml:"retries,omitempty"`
+}
+
+// EnvConfig holds environment variables of processes run by steps. Values
+// may refer to arguments as templates, or to variables of vexec as $NAME.
+type EnvConfig map[string]string
+
+func (e EnvConfig) clone() EnvConfig {
+	if e == nil {
+		return nil
+	}
+	n := make(EnvConfig, len(e))
+	for k, v := range e {
+		n[k] = v
+	}
+	return n
+}
+
+// List returns variables in form of KEY=VALUE, sorted by key.
+func (e EnvConfig) List() []string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	envs := make([]string, len(keys))
+	for i, k := range keys {
+		envs[i] = fmt.Sprintf("%s=%s", k, ReadEnvVariableIfHas(e[k]))
+	}
+	return envs
+}
+
+// SetArgs overrides args of every job, and of steps declaring them.
+func (c *ProjectConfig) SetArgs(args map[string]string) {
+	for _, job := range c.Jobs {
+		if job == nil {
+			continue
+		}
+		if job.Args == nil {
+			job.Args = &ArgsConfig{}
+		}
+		for k, v := range args {
+			(*job.Args)[k] = v
+		}
+		for _, step := range job.Steps {
+			if step.Args == nil {
+				continue
+			}
+			for k, v := range args {
+				if _, ok := (*step.Args)[k]; ok {
+					(*step.Args)[k] = v
+				}
+			}
+		}
+	}
+}
+
+// Clone returns a deep copy of job configuration.
+func (j *JobConfig) Clone() *JobConfig {
+	n := *j
+	n.Artifacts = append([]string(nil), j.Artifacts...)
+	n.Hosts = append([]string(nil), j.Hosts...)
+	n.Needs = append([]string(nil), j.Needs...)
+	n.Labels = append([]string(nil), j.Labels...)
+	if j.Approval != nil {
+		approval := *j.Approval
+		approval.Approvers = append([]string(nil), j.Approval.Approvers...)
+		n.Approval = &approval
+	}
+	if j.Concurrency != nil {
+		concurrency := *j.Concurrency
+		n.Concurrency = &concurrency
+	}
+	n.Args = j.Args.clone()
+	n.Env = j.Env.clone()
+	if j.Services != nil {
+		n.Services = make(map[string]*ServiceConfig, len(j.Services))
+		for k, v := range j.Services {
+			n.Services[k] = v
+		}
+	}
+	n.Steps = make([]StepConfig, len(j.Steps))
+	for i, step := range j.Steps {
+		if step.Retry != nil {
+			retry := *step.Retry
+			step.Retry = &retry
+		}
+		step.Args = step.Args.clone()
+		step.Env = step.Env.clone()
+		step.With = step.With.clone()
+		n.Steps[i] = step
+	}
+	return &n
 }
 
 type StepConfig struct {
-	Id   string      `yaml:"id,omitempty"`
-	Name string      `yaml:"name,omitempty"`
-	Run  string      `yaml:"run,omitempty"`
-	Use  string      `yaml:"use,omitempty"`
-	Args *ArgsConfig `yaml:"args,omitempty"`
-	With *ArgsConfig `yaml:"with,omitempty"`
+	Id              string       `yaml:"id,omitempty"`
+	Name            string       `yaml:"name,omitempty"`
+	If              string       `yaml:"if,omitempty"`
+	Run             string       `yaml:"run,omitempty"`
+	Use             string       `yaml:"use,omitempty"`
+	Shell           string       `yaml:"shell,omitempty"`
+	Env             EnvConfig    `yaml:"env,omitempty"`
+	Timeout         string       `yaml:"timeout,omitempty"`
+	Retry           *RetryConfig `yaml:"retry,omitempty"`
+	ContinueOnError bool         `yaml:"continue-on-error,omitempty"`
+	Args            *ArgsConfig  `yaml:"args,omitempty"`
+	With            *ArgsConfig  `yaml:"with,omitempty"`
+	// keys are the keys set in yaml, nil when step is not read from yaml.
+	keys map[string]bool
+}
+
+// Shells a run block can be executed with. With ShellNone, the default, every
+// line of the block is split into arguments and executed as a separate command.
+const (
+	ShellSh      = "sh"
+	ShellBash    = "bash"
+	ShellNone    = "none"
+	DefaultShell = ShellNone
+)
+
+// RetryConfig makes a failed step run again up to Attempts times in total.
+// The delay before each retry starts from Backoff and is doubled every time.
+type RetryConfig struct {
+	Attempts int    `yaml:"attempts,omitempty"`
+	Backoff  string `yaml:"backoff,omitempty"`
 }
 
 func ReadProjectConfig(configFile string) (c ProjectConfig, err error) {
@@ -83,11 +303,86 @@ func ReadProjectConfig(configFile string) (c ProjectConfig, err error) {
 
 //Docker config
 type DockerConfig struct {
-	Hosts      []string         `yaml:"hosts,omitempty"`
+	Hosts      []DockerHost     `yaml:"hosts,omitempty"`
 	Registries []RegistryConfig `yaml:"registries,omitempty"`
 }
+
+// DockerHost is the address of a docker host, e.g. tcp://10.0.0.1:2376 or
+// ssh://user@host, and the settings to connect it. It may be written as its
+// address alone.
+type DockerHost struct {
+	Address string `yaml:"address,omitempty"`
+	// Capacity is the number of containers host runs at once when jobs are
+	// placed on several hosts, the number of its CPUs when it is 0.
+	Capacity int              `yaml:"capacity,omitempty"`
+	TLS      *DockerTLSConfig `yaml:"tls,omitempty"`
+	SSH      *DockerSSHConfig `yaml:"ssh,omitempty"`
+}
+
+// DockerTLSConfig holds the files of the certificate authority and of the
+// client certificate connecting a docker host. Certificate of docker host is
+// checked against CA, or system roots without CA, unless InsecureSkipVerify
+// is set.
+type DockerTLSConfig struct {
+	CA                 string `yaml:"ca,omitempty"`
+	Cert               string `yaml:"cert,omitempty"`
+	Key                string `yaml:"key,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify,omitempty"`
+}
+
+// DockerSSHConfig tells how to log in a ssh:// docker host. Keys of
+// ssh-agent are tried before Key, whose passphrase may refer to an environment
+// variable. Key of host is checked against KnownHosts, ~/.ssh/known_hosts
+// when it is empty.
+type DockerSSHConfig struct {
+	Key        string `yaml:"key,omitempty"`
+	Passphrase string `yaml:"passphrase,omitempty"`
+	KnownHosts string `yaml:"known-hosts,omitempty"`
+}
+
+func (h *DockerHost) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var address string
+	if err := unmarshal(&address); err == nil {
+		*h = DockerHost{Address: address}
+		return nil
+	}
+	type plain DockerHost
+	return unmarshal((*plain)(h))
+}
+
+// DefaultDockerHosts returns the local docker hosts, through unix socket then
+// tcp.
+func DefaultDockerHosts() []DockerHost {
+	return []DockerHost{{Address: DefaultDockerUnixSock}, {Address: DefaultDockerTCPSock}}
+}
+
+// DockerHosts returns hosts of configuration, DefaultDockerHosts when there
+// is none.
+func (c DockerConfig) DockerHosts() []DockerHost {
+	if len(c.Hosts) == 0 {
+		return DefaultDockerHosts()
+	}
+	return c.Hosts
+}
+
 type RegistryConfig struct {
 	Address  string `yaml:"address,omitempty"`
 	Username string `yaml:"username,omitempty"`
 	Password string `yaml:"password,omitempty"`
 }
+
+// ReadDockerConfig reads docker hosts and registries from configFile.
+// Usernames and passwords of registries may refer to environment variables.
+func ReadDockerConfig(configFile string) (c DockerConfig, err error) {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		err = fmt.Errorf("read docker config file get error %v", err)
+		return
+	}
+	err = yaml.Unmarshal(data, &c)
+	if err != nil {
+		err = fmt.Errorf("unmarshal docker config file get error %v", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression made of five fields: minute, hour,
+// day of month, month and day of week. Fields accept *, lists, ranges, steps
+// and, for months and days of week, three letters names, e.g. "*/15 9-17 *
+// * MON-FRI". Macros @yearly, @monthly, @weekly, @daily and @hourly are
+// supported too.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny tell whether day of month or day of week is *. When
+	// both are restricted, a day matches if any of them matches.
+	domAny, dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dowNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// ParseCron parses a cron expression.
+func ParseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if v, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = v
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf(`cron expression %q must have 5 fields, got %d`, expr, len(fields))
+	}
+
+	s := &CronSchedule{}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf(`minute: %v`, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf(`hour: %v`, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf(`day of month: %v`, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf(`month: %v`, err)
+	}
+	// 7 is sunday as well
+	if s.dow, err = parseCronField(fields[4], 0, 7, dowNames); err != nil {
+		return nil, fmt.Errorf(`day of week: %v`, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domAny = fields[2] == "*" || fields[2] == "?"
+	s.dowAny = fields[4] == "*" || fields[4] == "?"
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf(`invalid step in %q`, part)
+			}
+		}
+
+		start, end := min, max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			i := strings.Index(rangePart, "-")
+			var err error
+			if start, err = cronValue(rangePart[:i], names); err != nil {
+				return 0, err
+			}
+			if end, err = cronValue(rangePart[i+1:], names); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := cronValue(rangePart, names)
+			if err != nil {
+				return 0, err
+			}
+			start = v
+			if step == 1 {
+				end = v
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf(`%q is out of range %d-%d`, part, min, max)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid value %q`, s)
+	}
+	return v, nil
+}
+
+// Match tells whether schedule fires at the minute of t, in location of t.
+func (s *CronSchedule) Match(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 ||
+		s.hour&(1<<uint(t.Hour())) == 0 ||
+		s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first minute after t at which schedule fires, or zero time
+// if it does not fire within five years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.Match(time.Date(t.Year(), t.Month(), t.Day(), firstBit(s.hour), firstBit(s.minute), 0, 0, t.Location())) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.Match(t) {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+func firstBit(bits uint64) int {
+	for i := 0; i < 64; i++ {
+		if bits&(1<<uint(i)) != 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// Schedule returns cron schedule of configuration. When cron is empty, repeat
+// is used instead: every-day fires at midnight and every-hour at the
+// beginning of every hour.
+func (s ScheduleConfig) Schedule() (*CronSchedule, error) {
+	if v := strings.TrimSpace(s.Cron); v != "" {
+		return ParseCron(v)
+	}
+	switch strings.TrimSpace(s.Repeated) {
+	case RepeatedEveryDay:
+		return ParseCron("@daily")
+	case RepeatedEveryHour:
+		return ParseCron("@hourly")
+	case "":
+		return nil, fmt.Errorf(`either cron or repeat must be specified`)
+	}
+	return nil, fmt.Errorf(`repeat must be %s or %s, got %q`, RepeatedEveryDay, RepeatedEveryHour, s.Repeated)
+}
+
+// Location returns time zone cron is evaluated in, local time zone by default.
+func (s ScheduleConfig) Location() (*time.Location, error) {
+	if v := strings.TrimSpace(s.Timezone); v != "" {
+		return time.LoadLocation(v)
+	}
+	return time.Local, nil
+}
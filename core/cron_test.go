@@ -0,0 +1,80 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		expr string
+		from string
+		want string
+	}{
+		{"*/15 * * * *", "2021-06-18 10:07:00", "2021-06-18 10:15:00"},
+		{"*/15 * * * *", "2021-06-18 10:15:00", "2021-06-18 10:30:00"},
+		{"*/15 * * * *", "2021-06-18 23:59:59", "2021-06-19 00:00:00"},
+		{"@hourly", "2021-06-18 10:59:30", "2021-06-18 11:00:00"},
+		{"@daily", "2021-12-31 12:00:00", "2022-01-01 00:00:00"},
+		{"0 9 * * MON-FRI", "2021-06-18 17:00:00", "2021-06-21 09:00:00"},
+		{"0 9-17/4 * * *", "2021-06-18 13:00:00", "2021-06-18 17:00:00"},
+		{"30 2 1 * *", "2021-01-31 08:00:00", "2021-02-01 02:30:00"},
+		{"0 0 29 2 *", "2021-03-01 00:00:00", "2024-02-29 00:00:00"},
+		{"0 0 1,15 * 1", "2021-06-01 00:00:00", "2021-06-07 00:00:00"},
+		{"0 0 * * 7", "2021-06-19 12:00:00", "2021-06-20 00:00:00"},
+		{"0 12 * jan,jul ?", "2021-02-01 00:00:00", "2021-07-01 12:00:00"},
+		{"0 0 31 2 *", "2021-01-01 00:00:00", "0001-01-01 00:00:00"},
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s: got %s, want %s", tt.expr, tt.from, got.Format("2006-01-02 15:04:05"), tt.want)
+		}
+	}
+}
+
+func TestCronNextInLocation(t *testing.T) {
+	loc := time.FixedZone("ICT", 7*60*60)
+	s, err := ParseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Next(time.Date(2021, 6, 18, 9, 0, 0, 0, loc))
+	if want := time.Date(2021, 6, 19, 9, 0, 0, 0, loc); !got.Equal(want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"* * * *", `cron expression "* * * *" must have 5 fields, got 4`},
+		{"@every 5m", `must have 5 fields, got 2`},
+		{"60 * * * *", `minute: "60" is out of range 0-59`},
+		{"* 24 * * *", `hour: "24" is out of range 0-23`},
+		{"* * 0 * *", `day of month: "0" is out of range 1-31`},
+		{"* * * foo *", `month: invalid value "foo"`},
+		{"* * * * 5-1", `day of week: "5-1" is out of range 0-7`},
+		{"*/0 * * * *", `minute: invalid step in "*/0"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseCron(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %s", tt.expr, err, tt.err)
+		}
+	}
+}
@@ -8,13 +8,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"strings"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/go-connections/tlsconfig"
 )
 
 var (
@@ -26,17 +29,24 @@ var (
 
 type DockerClient struct {
 	Client *client.Client
+	// Host is the docker host Client is connected to.
+	Host DockerHost
 	DockerConfig
+	tunnel *sshTunnel
 }
 
 func (c *DockerClient) Close() {
 	if c.Client != nil {
 		_ = c.Client.Close()
 	}
+	if c.tunnel != nil {
+		c.tunnel.Close()
+	}
 }
 
 func (c *DockerClient) ImageExist(ctx context.Context, imageRef string) (bool, []string, error) {
-	if !strings.Contains(imageRef, ":") {
+	//a port of registry is not a tag
+	if name := imageRef[strings.LastIndex(imageRef, "/")+1:]; !strings.ContainsAny(name, ":@") {
 		imageRef = fmt.Sprintf("%s:latest", imageRef)
 	}
 	args := filters.NewArgs(filters.KeyValuePair{
@@ -70,6 +80,63 @@ func (c *DockerClient) PullImage(ctx context.Context, registry RegistryConfig, r
 	return c.Client.ImagePull(ctx, reference, opt)
 }
 
+// ImageDigest returns id of image and the reference pinning it by digest,
+// e.g. alpine@sha256:..., which is empty when image was not pulled from a
+// registry.
+func (c *DockerClient) ImageDigest(ctx context.Context, imageRef string) (string, string, error) {
+	img, _, err := c.Client.ImageInspectWithRaw(ctx, imageRef)
+	if err != nil {
+		return "", "", err
+	}
+	name := ""
+	if named, err := reference.ParseNormalizedNamed(imageRef); err == nil {
+		name = named.Name()
+	}
+	for _, d := range img.RepoDigests {
+		if named, err := reference.ParseNormalizedNamed(d); err == nil && named.Name() == name {
+			return img.ID, d, nil
+		}
+	}
+	if len(img.RepoDigests) > 0 {
+		return img.ID, img.RepoDigests[0], nil
+	}
+	return img.ID, "", nil
+}
+
+// Registry returns the registry of configuration whose address is the
+// domain of image reference, DefaultDockerHubRegistry when there is none.
+// Images without domain belong to Docker Hub.
+func (c DockerConfig) Registry(imageRef string) RegistryConfig {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return DefaultDockerHubRegistry
+	}
+	domain := reference.Domain(named)
+	for _, registry := range c.Registries {
+		if registryDomain(registry.Address) == domain {
+			return registry
+		}
+	}
+	return DefaultDockerHubRegistry
+}
+
+// registryDomain returns domain of registry address, which may be an url
+// such as https://index.docker.io/v1/.
+func registryDomain(address string) string {
+	address = strings.TrimSpace(address)
+	if i := strings.Index(address, "://"); i >= 0 {
+		address = address[i+3:]
+	}
+	if i := strings.Index(address, "/"); i >= 0 {
+		address = address[:i]
+	}
+	switch address {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return address
+}
+
 func (c *DockerClient) RemoveImage(ctx context.Context, imageId string) ([]types.ImageDeleteResponseItem, error) {
 	return c.Client.ImageRemove(ctx, imageId, types.ImageRemoveOptions{
 		PruneChildren: true,
@@ -122,48 +189,89 @@ func auth(username, password string) (string, error) {
 	return base64.URLEncoding.EncodeToString(encodedJSON), nil
 }
 
-func ConnectDockerHost(ctx context.Context, hosts []string) (dockerCli DockerClient, err error) {
-	host, err := VerifyDockerHostConnection(ctx, hosts)
-	if err != nil {
+// NewDockerClient returns a client of docker host. Its options are explicit,
+// so that clients of several hosts may be used at the same time. The docker
+// API of a ssh:// host is tunnelled through a ssh connection.
+func NewDockerClient(host DockerHost) (dockerCli DockerClient, err error) {
+	address := strings.TrimSpace(host.Address)
+	if address == "" {
+		err = fmt.Errorf("address of docker host is missing")
 		return
 	}
-	_ = os.Setenv("DOCKER_HOST", host)
-	dockerCli.Client, err = client.NewClientWithOpts(client.FromEnv)
+	dockerCli.Host = host
+	opts := make([]client.Opt, 0)
+	if host.TLS != nil {
+		tlsConfig, terr := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             host.TLS.CA,
+			CertFile:           host.TLS.Cert,
+			KeyFile:            host.TLS.Key,
+			InsecureSkipVerify: host.TLS.InsecureSkipVerify,
+			ExclusiveRootPools: true,
+		})
+		if terr != nil {
+			err = fmt.Errorf("invalid tls configuration of docker host %s: %v", address, terr)
+			return
+		}
+		opts = append(opts, client.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}))
+	}
+	if strings.HasPrefix(address, "ssh://") {
+		dockerCli.tunnel, err = newSSHTunnel(address, host.SSH)
+		if err != nil {
+			err = fmt.Errorf("invalid docker host %s: %v", address, err)
+			return
+		}
+		//requests are plain http inside the tunnel
+		opts = append(opts, client.WithHost("http://docker.example.com"),
+			client.WithDialContext(dockerCli.tunnel.DialContext))
+	} else {
+		//host configures transport of http client, so it comes last
+		opts = append(opts, client.WithHost(address))
+	}
+	dockerCli.Client, err = client.NewClientWithOpts(opts...)
 	if err != nil {
-		return
+		dockerCli.Close()
+		err = fmt.Errorf("invalid docker host %s: %v", address, err)
 	}
 	return
 }
 
-func VerifyDockerHostConnection(ctx context.Context, dockerHosts []string) (string, error) {
-	var err error
-	for _, host := range dockerHosts {
-		err = os.Setenv("DOCKER_HOST", host)
+// ConnectDockerHost returns a client of the first of hosts answering.
+func ConnectDockerHost(ctx context.Context, hosts []DockerHost) (dockerCli DockerClient, err error) {
+	for _, host := range hosts {
+		dockerCli, err = NewDockerClient(host)
 		if err != nil {
 			continue
 		}
-		cli, err := client.NewClientWithOpts(client.FromEnv)
-		if err != nil {
-			continue
-		}
-		if cli == nil {
-			err = fmt.Errorf("can not init docker client")
-			continue
-		}
-		_, err = cli.Info(ctx)
+		_, err = dockerCli.Client.Info(ctx)
 		if err != nil {
-			_ = cli.Close()
+			dockerCli.Close()
+			err = fmt.Errorf("%s: %v", host.Address, err)
 			continue
 		}
-		_ = cli.Close()
-		return host, nil
+		return
+	}
+	dockerCli = DockerClient{}
+	if err == nil {
+		err = fmt.Errorf("no docker host is configured")
 	}
+	err = fmt.Errorf("connect docker host error: %v", err)
+	return
+}
+
+// VerifyDockerHostConnection returns the first of docker hosts answering.
+func VerifyDockerHostConnection(ctx context.Context, dockerHosts []DockerHost) (DockerHost, error) {
+	dockerCli, err := ConnectDockerHost(ctx, dockerHosts)
 	if err != nil {
-		return "", fmt.Errorf("connect docker host error: %v", err)
+		return DockerHost{}, err
 	}
-	return "", nil
+	dockerCli.Close()
+	return dockerCli.Host, nil
 }
 
+// DisplayDockerLog returns output of a build, or statuses of a pull, read
+// from in. Progress of layers is left out.
 func DisplayDockerLog(in io.Reader) (string, error) {
 	var buf bytes.Buffer
 	defer buf.Reset()
@@ -179,10 +287,17 @@ func DisplayDockerLog(in io.Reader) (string, error) {
 		if jm.Error != nil {
 			return "", fmt.Errorf(jm.Error.Message)
 		}
-		if jm.Stream == "" {
+		if jm.Stream != "" {
+			buf.WriteString(jm.Stream)
 			continue
 		}
-		buf.WriteString(jm.Stream)
+		if jm.Status == "" || jm.Progress != nil {
+			continue
+		}
+		if jm.ID != "" {
+			buf.WriteString(jm.ID + ": ")
+		}
+		buf.WriteString(jm.Status + "\n")
 	}
 	return buf.String(), nil
 }
@@ -205,3 +320,7 @@ func RemoveAfterDone(cli *client.Client, id string) {
 		Force: true,
 	})
 }
+
+func RemoveNetworkAfterDone(cli *client.Client, id string) {
+	_ = cli.NetworkRemove(context.Background(), id)
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDockerTLSVerifiesHost(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ID":"test"}`))
+	}))
+	defer srv.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	address := "tcp://" + strings.TrimPrefix(srv.URL, "https://")
+
+	tests := []struct {
+		name string
+		tls  DockerTLSConfig
+		ok   bool
+	}{
+		{"system roots", DockerTLSConfig{}, false},
+		{"ca of host", DockerTLSConfig{CA: ca}, true},
+		{"insecure", DockerTLSConfig{InsecureSkipVerify: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig := tt.tls
+			cli, err := NewDockerClient(DockerHost{Address: address, TLS: &tlsConfig})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cli.Close()
+			_, err = cli.Client.Info(context.Background())
+			if tt.ok && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "certificate")) {
+				t.Fatalf("certificate of host must be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRegistryOfImage(t *testing.T) {
+	config := DockerConfig{Registries: []RegistryConfig{
+		{Address: "registry.example.com:5000", Username: "ci"},
+		{Address: "https://ghcr.io/v2/", Username: "bot"},
+		{Address: "https://index.docker.io/v1/", Username: "hub"},
+	}}
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"registry.example.com:5000/team/app:1.0", "ci"},
+		{"registry.example.com/team/app:1.0", ""},
+		{"ghcr.io/owner/tool@sha256:074d3636ebda6dd446d0d00304c4454f468237fdacf08fb0eeac90bdbfa1bac7", "bot"},
+		{"alpine", "hub"},
+		{"library/alpine:3.14", "hub"},
+		{"docker.io/owner/app", "hub"},
+		{"Invalid Reference", ""},
+	}
+	for _, tt := range tests {
+		if got := config.Registry(tt.image); got.Username != tt.want {
+			t.Errorf("Registry(%s) = %+v, want the one of %q", tt.image, got, tt.want)
+		}
+	}
+	if got := (DockerConfig{}).Registry("alpine"); got != DefaultDockerHubRegistry {
+		t.Errorf("image without registry configured got %+v", got)
+	}
+}
+
+func TestRegistryDomain(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"registry.example.com:5000", "registry.example.com:5000"},
+		{" https://registry.example.com/v2/ ", "registry.example.com"},
+		{"http://localhost:5000", "localhost:5000"},
+		{"https://index.docker.io/v1/", "docker.io"},
+		{"registry-1.docker.io", "docker.io"},
+		{"registry.hub.docker.com", "docker.io"},
+		{"docker.io", "docker.io"},
+	}
+	for _, tt := range tests {
+		if got := registryDomain(tt.address); got != tt.want {
+			t.Errorf("registryDomain(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
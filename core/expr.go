@@ -0,0 +1,529 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	StatusSuccess   = "success"
+	StatusFailure   = "failure"
+	StatusSkipped   = "skipped"
+	StatusCancelled = "cancelled"
+	// StatusRejected is the status of a job whose approval is refused or
+	// timed out.
+	StatusRejected = "rejected"
+)
+
+// ExprContext holds values an if expression is evaluated against.
+//
+// Status is the status of the job so far for a step condition, or the
+// combined status of needed jobs for a job condition. Steps and Needs hold
+// status of previous steps and needed jobs by their id. When Env is nil,
+// environment variables of the current process are used.
+type ExprContext struct {
+	Status string
+	Args   map[string]string
+	Matrix map[string]string
+	Env    map[string]string
+	Steps  map[string]string
+	Needs  map[string]string
+}
+
+// EvalCondition evaluates an if expression. An empty expression is the same
+// as success(). An expression which does not call any of the status functions
+// success(), failure(), always() or cancelled() is only true if success() is.
+//
+// Supported syntax:
+//   - literals: 'string', "string", numbers, true, false, null
+//   - values: args.<name>, env.<name>, matrix.<name>, steps.<id>.status,
+//     steps.<id>.outputs.<key>, needs.<id>.result or a bare argument name
+//     such as steps_<id>_outputs_<key>
+//   - operators: ==, !=, <, <=, >, >=, &&, ||, ! and parentheses
+//   - functions: success(), failure(), always(), cancelled(), contains(s, sub),
+//     startsWith(s, prefix), endsWith(s, suffix), toLower(s), toUpper(s)
+func EvalCondition(expr string, ctx ExprContext) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	expr = strings.TrimSuffix(strings.TrimPrefix(expr, "${{"), "}}")
+	if strings.TrimSpace(expr) == "" {
+		expr = "success()"
+	}
+	n, err := parseExpr(expr)
+	if err != nil {
+		return false, err
+	}
+	if !n.hasStatusCall() {
+		n = &exprNode{op: "&&", left: &exprNode{op: "call", value: "success"}, right: n}
+	}
+	v, err := n.eval(ctx)
+	if err != nil {
+		return false, fmt.Errorf(`failed to evaluate "%s": %v`, expr, err)
+	}
+	return truthy(v), nil
+}
+
+// CheckCondition reports syntax error of an if expression, if any.
+func CheckCondition(expr string) error {
+	expr = strings.TrimSpace(expr)
+	expr = strings.TrimSuffix(strings.TrimPrefix(expr, "${{"), "}}")
+	if strings.TrimSpace(expr) == "" {
+		return nil
+	}
+	_, err := parseExpr(expr)
+	return err
+}
+
+var statusFuncs = map[string]struct{}{
+	"success":   {},
+	"failure":   {},
+	"always":    {},
+	"cancelled": {},
+}
+
+// exprFuncs maps name of supported functions to their number of arguments.
+var exprFuncs = map[string]int{
+	"success":    0,
+	"failure":    0,
+	"always":     0,
+	"cancelled":  0,
+	"contains":   2,
+	"startsWith": 2,
+	"endsWith":   2,
+	"toLower":    1,
+	"toUpper":    1,
+}
+
+type exprNode struct {
+	op    string
+	value interface{}
+	left  *exprNode
+	right *exprNode
+	args  []*exprNode
+}
+
+func (n *exprNode) hasStatusCall() bool {
+	if n == nil {
+		return false
+	}
+	if n.op == "call" {
+		if _, ok := statusFuncs[n.value.(string)]; ok {
+			return true
+		}
+	}
+	for _, a := range n.args {
+		if a.hasStatusCall() {
+			return true
+		}
+	}
+	return n.left.hasStatusCall() || n.right.hasStatusCall()
+}
+
+func (n *exprNode) eval(ctx ExprContext) (interface{}, error) {
+	switch n.op {
+	case "literal":
+		return n.value, nil
+	case "ident":
+		return ctx.lookup(n.value.(string)), nil
+	case "!":
+		v, err := n.left.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return !truthy(v), nil
+	case "&&", "||":
+		l, err := n.left.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "&&" && !truthy(l) {
+			return false, nil
+		}
+		if n.op == "||" && truthy(l) {
+			return true, nil
+		}
+		r, err := n.right.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(r), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		l, err := n.left.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r, err := n.right.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return compare(n.op, l, r), nil
+	case "call":
+		return n.call(ctx)
+	}
+	return nil, fmt.Errorf(`unknown operator %s`, n.op)
+}
+
+func (n *exprNode) call(ctx ExprContext) (interface{}, error) {
+	name := n.value.(string)
+	args := make([]string, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = toString(v)
+	}
+	want, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf(`unknown function %s`, name)
+	}
+	if len(args) != want {
+		return nil, fmt.Errorf(`function %s expects %d argument(s) but got %d`, name, want, len(args))
+	}
+
+	switch name {
+	case "success":
+		return ctx.Status == "" || ctx.Status == StatusSuccess, nil
+	case "failure":
+		return ctx.Status == StatusFailure, nil
+	case "cancelled":
+		return ctx.Status == StatusCancelled, nil
+	case "always":
+		return true, nil
+	case "contains":
+		return strings.Contains(args[0], args[1]), nil
+	case "startsWith":
+		return strings.HasPrefix(args[0], args[1]), nil
+	case "endsWith":
+		return strings.HasSuffix(args[0], args[1]), nil
+	case "toLower":
+		return strings.ToLower(args[0]), nil
+	default:
+		return strings.ToUpper(args[0]), nil
+	}
+}
+
+func (ctx ExprContext) lookup(name string) interface{} {
+	parts := strings.Split(name, ".")
+	var v string
+	var ok bool
+	switch {
+	case len(parts) == 2 && parts[0] == "args":
+		v, ok = ctx.Args[parts[1]]
+	case len(parts) == 2 && parts[0] == "env" && ctx.Env != nil:
+		v, ok = ctx.Env[parts[1]]
+	case len(parts) == 2 && parts[0] == "env":
+		v, ok = os.LookupEnv(parts[1])
+	case len(parts) == 2 && parts[0] == "matrix":
+		v, ok = ctx.Matrix[parts[1]]
+	case len(parts) == 3 && parts[0] == "steps" && (parts[2] == "status" || parts[2] == "outcome"):
+		v, ok = ctx.Steps[parts[1]]
+	case len(parts) == 4 && parts[0] == "steps" && parts[2] == "outputs":
+		v, ok = ctx.Args[fmt.Sprintf(`steps_%s_outputs_%s`, parts[1], parts[3])]
+	case len(parts) == 3 && parts[0] == "needs" && parts[2] == "result":
+		v, ok = ctx.Needs[parts[1]]
+	case len(parts) == 1:
+		v, ok = ctx.Args[name]
+	}
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	}
+	return true
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func compare(op string, l, r interface{}) bool {
+	c := 0
+	lf, lok := toNumber(l)
+	rf, rok := toNumber(r)
+	if lok && rok {
+		if lf < rf {
+			c = -1
+		} else if lf > rf {
+			c = 1
+		}
+	} else {
+		c = strings.Compare(toString(l), toString(r))
+	}
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+type exprToken struct {
+	kind  string
+	value string
+	pos   int
+}
+
+type exprParser struct {
+	expr   string
+	tokens []exprToken
+	i      int
+}
+
+// parseExpr builds syntax tree of an if expression.
+func parseExpr(expr string) (*exprNode, error) {
+	tokens, err := tokenizeExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{expr: expr, tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, p.unexpected(t)
+	}
+	return n, nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.i]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.i]
+	if t.kind != "eof" {
+		p.i++
+	}
+	return t
+}
+
+func (p *exprParser) unexpected(t exprToken) error {
+	if t.kind == "eof" {
+		return fmt.Errorf(`invalid expression "%s": unexpected end of expression`, p.expr)
+	}
+	return fmt.Errorf(`invalid expression "%s": unexpected %s at position %d`, p.expr, t.value, t.pos+1)
+}
+
+func (p *exprParser) parseOr() (*exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (*exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	if p.peek().kind == "!" {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{op: "!", left: n}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (*exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	switch k := p.peek().kind; k {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{op: k, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case "string":
+		return &exprNode{op: "literal", value: t.value}, nil
+	case "number":
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid expression "%s": invalid number %s`, p.expr, t.value)
+		}
+		return &exprNode{op: "literal", value: f}, nil
+	case "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != ")" {
+			return nil, p.unexpected(c)
+		}
+		return n, nil
+	case "ident":
+		switch t.value {
+		case "true":
+			return &exprNode{op: "literal", value: true}, nil
+		case "false":
+			return &exprNode{op: "literal", value: false}, nil
+		case "null":
+			return &exprNode{op: "literal", value: nil}, nil
+		}
+		if p.peek().kind != "(" {
+			return &exprNode{op: "ident", value: t.value}, nil
+		}
+		if _, ok := exprFuncs[t.value]; !ok {
+			return nil, fmt.Errorf(`invalid expression "%s": unknown function %s at position %d`, p.expr, t.value, t.pos+1)
+		}
+		p.next()
+		n := &exprNode{op: "call", value: t.value}
+		if p.peek().kind == ")" {
+			p.next()
+			return n, nil
+		}
+		for {
+			a, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			n.args = append(n.args, a)
+			c := p.next()
+			if c.kind == ")" {
+				return n, nil
+			}
+			if c.kind != "," {
+				return nil, p.unexpected(c)
+			}
+		}
+	}
+	return nil, p.unexpected(t)
+}
+
+func tokenizeExpr(expr string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(expr); {
+		r := expr[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			i++
+		case r == '\'' || r == '"':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(expr) && expr[j] != r; j++ {
+				if expr[j] == '\\' && j+1 < len(expr) {
+					j++
+				}
+				b.WriteByte(expr[j])
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf(`invalid expression "%s": unterminated string at position %d`, expr, i+1)
+			}
+			tokens = append(tokens, exprToken{kind: "string", value: b.String(), pos: i})
+			i = j + 1
+		case '0' <= r && r <= '9':
+			j := i
+			for j < len(expr) && (('0' <= expr[j] && expr[j] <= '9') || expr[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: "number", value: expr[i:j], pos: i})
+			i = j
+		case isIdentByte(r, true):
+			j := i
+			for j < len(expr) && isIdentByte(expr[j], false) {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", value: expr[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", ","} {
+				if strings.HasPrefix(expr[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf(`invalid expression "%s": unexpected character %c at position %d`, expr, r, i+1)
+			}
+			tokens = append(tokens, exprToken{kind: op, value: op, pos: i})
+			i += len(op)
+		}
+	}
+	tokens = append(tokens, exprToken{kind: "eof", pos: len(expr)})
+	return tokens, nil
+}
+
+func isIdentByte(r byte, first bool) bool {
+	if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || r == '_' {
+		return true
+	}
+	if first {
+		return false
+	}
+	return ('0' <= r && r <= '9') || r == '.' || r == '-'
+}
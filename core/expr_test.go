@@ -0,0 +1,88 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalCondition(t *testing.T) {
+	ctx := ExprContext{
+		Status: StatusSuccess,
+		Args:   map[string]string{"version": "1.2.0", "count": "10", "steps_build_outputs_image": "app:1.2.0"},
+		Matrix: map[string]string{"os": "linux"},
+		Env:    map[string]string{"BRANCH": "main"},
+		Steps:  map[string]string{"build": StatusSuccess, "lint": StatusFailure},
+		Needs:  map[string]string{"test": StatusSkipped},
+	}
+	failed := ctx
+	failed.Status = StatusFailure
+	cancelled := ctx
+	cancelled.Status = StatusCancelled
+
+	tests := []struct {
+		expr string
+		ctx  ExprContext
+		want bool
+	}{
+		{"", ctx, true},
+		{"", failed, false},
+		{"${{ success() }}", ctx, true},
+		{"failure()", failed, true},
+		{"failure()", ctx, false},
+		{"always()", cancelled, true},
+		{"cancelled()", cancelled, true},
+		{"!cancelled()", failed, true},
+		{"true", failed, false},
+		{"matrix.os == 'linux'", ctx, true},
+		{"matrix.os == 'linux'", failed, false},
+		{"failure() && matrix.os == 'linux'", failed, true},
+		{"env.BRANCH == \"main\" || env.BRANCH == 'develop'", ctx, true},
+		{"args.version != '1.2.0'", ctx, false},
+		{"version == '1.2.0'", ctx, true},
+		{"steps.build.status == 'success'", ctx, true},
+		{"steps.lint.outcome == 'failure'", ctx, true},
+		{"steps.build.outputs.image == 'app:1.2.0'", ctx, true},
+		{"needs.test.result == 'skipped'", ctx, true},
+		{"args.count > 9", ctx, true},
+		{"args.count < 9.5", ctx, false},
+		{"args.count >= '10'", ctx, true},
+		{"args.missing", ctx, false},
+		{"args.missing == null", ctx, true},
+		{"!(args.count > 9 && false)", ctx, true},
+		{"contains(args.version, '.2.')", ctx, true},
+		{"startsWith(env.BRANCH, 'ma') && endsWith(env.BRANCH, 'in')", ctx, true},
+		{"toUpper(matrix.os) == 'LINUX' && toLower('ABC') == 'abc'", ctx, true},
+		{"'it\\'s' == \"it's\"", ctx, true},
+	}
+	for _, tt := range tests {
+		got, err := EvalCondition(tt.expr, tt.ctx)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q with status %s: got %v, want %v", tt.expr, tt.ctx.Status, got, tt.want)
+		}
+	}
+}
+
+func TestEvalConditionErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"matrix.os ==", "unexpected end of expression"},
+		{"(success()", "unexpected end of expression"},
+		{"success() success()", "unexpected success at position 11"},
+		{"'open", "unterminated string at position 1"},
+		{"args.a = 'b'", "unexpected character = at position 8"},
+		{"run('x')", "unknown function run at position 1"},
+		{"contains('a')", "function contains expects 2 argument(s) but got 1"},
+	}
+	for _, tt := range tests {
+		_, err := EvalCondition(tt.expr, ExprContext{})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %s", tt.expr, err, tt.err)
+		}
+	}
+}
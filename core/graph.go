@@ -0,0 +1,83 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	visitNone = iota
+	visitInProgress
+	visitDone
+)
+
+// PlanJobs returns ids of jobs in the order they must be run so that every job
+// comes after the jobs it needs. When targets is not empty, only these jobs and
+// their transitive dependencies are planned.
+func PlanJobs(jobs map[string]*JobConfig, targets ...string) ([]string, error) {
+	for id, job := range jobs {
+		for _, need := range job.Needs {
+			need = strings.TrimSpace(need)
+			if _, ok := jobs[need]; !ok {
+				return nil, fmt.Errorf(`job %s needs unknown job %s`, id, need)
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		for id := range jobs {
+			targets = append(targets, id)
+		}
+	} else {
+		for _, id := range targets {
+			if _, ok := jobs[id]; !ok {
+				return nil, fmt.Errorf(`job %s not found`, id)
+			}
+		}
+	}
+	sort.Strings(targets)
+
+	states := make(map[string]int)
+	order := make([]string, 0)
+	var path []string
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch states[id] {
+		case visitDone:
+			return nil
+		case visitInProgress:
+			cycle := []string{id}
+			for i := len(path) - 1; i >= 0; i-- {
+				cycle = append([]string{path[i]}, cycle...)
+				if path[i] == id {
+					break
+				}
+			}
+			return fmt.Errorf(`dependency cycle detected: %s`, strings.Join(cycle, " -> "))
+		}
+		states[id] = visitInProgress
+		path = append(path, id)
+		needs := make([]string, 0, len(jobs[id].Needs))
+		for _, need := range jobs[id].Needs {
+			needs = append(needs, strings.TrimSpace(need))
+		}
+		sort.Strings(needs)
+		for _, need := range needs {
+			if err := visit(need); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		states[id] = visitDone
+		order = append(order, id)
+		return nil
+	}
+
+	for _, id := range targets {
+		if err := visit(id); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
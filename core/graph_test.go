@@ -0,0 +1,85 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		needs   map[string][]string
+		targets []string
+		want    []string
+		err     string
+	}{
+		{
+			name:  "independent jobs in order of id",
+			needs: map[string][]string{"lint": nil, "build": nil, "doc": nil},
+			want:  []string{"build", "doc", "lint"},
+		},
+		{
+			name:  "needs come first",
+			needs: map[string][]string{"deploy": {"test"}, "test": {"build"}, "build": nil},
+			want:  []string{"build", "test", "deploy"},
+		},
+		{
+			name:  "diamond",
+			needs: map[string][]string{"a": nil, "b": {"a"}, "c": {"a"}, "d": {"c", "b"}},
+			want:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name:  "needs are trimmed",
+			needs: map[string][]string{"build": nil, "test": {" build "}},
+			want:  []string{"build", "test"},
+		},
+		{
+			name:    "targets and their needs only",
+			needs:   map[string][]string{"build": nil, "test": {"build"}, "lint": nil, "deploy": {"test"}},
+			targets: []string{"test"},
+			want:    []string{"build", "test"},
+		},
+		{
+			name:    "unknown target",
+			needs:   map[string][]string{"build": nil},
+			targets: []string{"deploy"},
+			err:     "job deploy not found",
+		},
+		{
+			name:  "unknown need",
+			needs: map[string][]string{"test": {"build"}},
+			err:   "job test needs unknown job build",
+		},
+		{
+			name:  "cycle",
+			needs: map[string][]string{"a": {"c"}, "b": {"a"}, "c": {"b"}},
+			err:   "dependency cycle detected: a -> c -> b -> a",
+		},
+		{
+			name:  "job needing itself",
+			needs: map[string][]string{"a": {"a"}},
+			err:   "dependency cycle detected: a -> a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := make(map[string]*JobConfig)
+			for id, needs := range tt.needs {
+				jobs[id] = &JobConfig{Needs: needs}
+			}
+			got, err := PlanJobs(jobs, tt.targets...)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MatrixConfig describes combinations of values a job is run with. Every key
+// except include and exclude is an axis with a list of values, e.g.
+//
+//	matrix:
+//	  go: ["1.16", "1.17"]
+//	  arch: [amd64, arm64]
+//	  exclude:
+//	    - go: "1.16"
+//	      arch: arm64
+//	  include:
+//	    - go: "1.15"
+//	      arch: amd64
+type MatrixConfig struct {
+	Axes    []MatrixAxis
+	Include []map[string]string
+	Exclude []map[string]string
+}
+
+type MatrixAxis struct {
+	Name   string
+	Values []string
+}
+
+func (m *MatrixConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var extra struct {
+		Include []map[string]string `yaml:"include,omitempty"`
+		Exclude []map[string]string `yaml:"exclude,omitempty"`
+	}
+	var axes yaml.MapSlice
+	if err := unmarshal(&axes); err != nil {
+		return err
+	}
+	if err := unmarshal(&extra); err != nil {
+		return err
+	}
+	m.Include = extra.Include
+	m.Exclude = extra.Exclude
+	m.Axes = nil
+	for _, item := range axes {
+		name := strings.TrimSpace(fmt.Sprintf("%v", item.Key))
+		if name == "include" || name == "exclude" {
+			continue
+		}
+		values, ok := item.Value.([]interface{})
+		if !ok {
+			return fmt.Errorf(`matrix axis %s must be a list of values`, name)
+		}
+		axis := MatrixAxis{Name: name}
+		for _, v := range values {
+			axis.Values = append(axis.Values, fmt.Sprintf("%v", v))
+		}
+		m.Axes = append(m.Axes, axis)
+	}
+	return nil
+}
+
+func (m MatrixConfig) MarshalYAML() (interface{}, error) {
+	var out yaml.MapSlice
+	for _, axis := range m.Axes {
+		out = append(out, yaml.MapItem{Key: axis.Name, Value: axis.Values})
+	}
+	if len(m.Include) > 0 {
+		out = append(out, yaml.MapItem{Key: "include", Value: m.Include})
+	}
+	if len(m.Exclude) > 0 {
+		out = append(out, yaml.MapItem{Key: "exclude", Value: m.Exclude})
+	}
+	return out, nil
+}
+
+// Combinations returns every combination of axis values, after removing the
+// excluded ones and appending the included ones.
+func (m MatrixConfig) Combinations() []map[string]string {
+	combinations := []map[string]string{{}}
+	for _, axis := range m.Axes {
+		var next []map[string]string
+		for _, c := range combinations {
+			for _, v := range axis.Values {
+				n := make(map[string]string, len(c)+1)
+				for k, cv := range c {
+					n[k] = cv
+				}
+				n[axis.Name] = v
+				next = append(next, n)
+			}
+		}
+		combinations = next
+	}
+	if len(m.Axes) == 0 {
+		combinations = nil
+	}
+
+	result := make([]map[string]string, 0, len(combinations))
+	for _, c := range combinations {
+		excluded := false
+		for _, e := range m.Exclude {
+			if matchCombination(c, e) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			result = append(result, c)
+		}
+	}
+
+	for _, inc := range m.Include {
+		exist := false
+		for _, c := range result {
+			if len(c) == len(inc) && matchCombination(c, inc) {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			result = append(result, inc)
+		}
+	}
+	return result
+}
+
+// Id returns derived id of a job run with combination c,
+// e.g. build[go=1.16,arch=arm64].
+func (m MatrixConfig) Id(jobId string, c map[string]string) string {
+	keys := make([]string, 0, len(c))
+	seen := make(map[string]struct{})
+	for _, axis := range m.Axes {
+		if _, ok := c[axis.Name]; ok {
+			keys = append(keys, axis.Name)
+			seen[axis.Name] = struct{}{}
+		}
+	}
+	var others []string
+	for k := range c {
+		if _, ok := seen[k]; !ok {
+			others = append(others, k)
+		}
+	}
+	sort.Strings(others)
+	keys = append(keys, others...)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%s", k, c[k])
+	}
+	return fmt.Sprintf("%s[%s]", jobId, strings.Join(pairs, ","))
+}
+
+func matchCombination(c, filter map[string]string) bool {
+	for k, v := range filter {
+		if c[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ExpandMatrix replaces every job declaring a matrix by one job per
+// combination. Jobs that need an expanded job then need all of its
+// combinations.
+func (c *ProjectConfig) ExpandMatrix() error {
+	expanded := make(map[string][]string)
+	jobs := make(map[string]*JobConfig)
+	for id, job := range c.Jobs {
+		id = strings.TrimSpace(id)
+		if job.Matrix == nil {
+			jobs[id] = job
+			continue
+		}
+		combinations := job.Matrix.Combinations()
+		if len(combinations) == 0 {
+			return fmt.Errorf(`matrix of job %s has no combination`, id)
+		}
+		for _, combination := range combinations {
+			j, err := job.expand(combination)
+			if err != nil {
+				return fmt.Errorf(`failed to expand matrix of job %s: %v`, id, err)
+			}
+			j.Id = job.Matrix.Id(id, combination)
+			jobs[j.Id] = j
+			expanded[id] = append(expanded[id], j.Id)
+		}
+	}
+
+	for _, job := range jobs {
+		var needs []string
+		for _, need := range job.Needs {
+			if ids, ok := expanded[strings.TrimSpace(need)]; ok {
+				needs = append(needs, ids...)
+				continue
+			}
+			needs = append(needs, need)
+		}
+		job.Needs = needs
+	}
+	c.Jobs = jobs
+	return nil
+}
+
+// FindJobs returns ids of jobs matched by id. A job declaring a matrix is
+// matched by its original id as well as by its derived ids.
+func (c ProjectConfig) FindJobs(id string) []string {
+	if _, ok := c.Jobs[id]; ok {
+		return []string{id}
+	}
+	var ids []string
+	for k := range c.Jobs {
+		if strings.HasPrefix(k, id+"[") {
+			ids = append(ids, k)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (j *JobConfig) expand(combination map[string]string) (*JobConfig, error) {
+	n := j.Clone()
+	n.Matrix = nil
+	n.MatrixValues = combination
+	data := map[string]interface{}{
+		"matrix": combination,
+	}
+
+	var err error
+	n.RunOn, err = RenderTemplate(n.RunOn, data)
+	if err != nil {
+		return nil, fmt.Errorf(`run-on: %v`, err)
+	}
+	if n.Name != "" {
+		n.Name, err = RenderTemplate(n.Name, data)
+		if err != nil {
+			return nil, fmt.Errorf(`name: %v`, err)
+		}
+	}
+	if n.Args != nil {
+		for k, v := range *n.Args {
+			(*n.Args)[k], err = RenderTemplate(v, data)
+			if err != nil {
+				return nil, fmt.Errorf(`args.%s: %v`, k, err)
+			}
+		}
+	}
+	return n, nil
+}
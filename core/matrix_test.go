@@ -0,0 +1,107 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMatrixCombinations(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix string
+		want   []string
+	}{
+		{
+			name:   "single axis",
+			matrix: `go: ["1.16", "1.17"]`,
+			want:   []string{"build[go=1.16]", "build[go=1.17]"},
+		},
+		{
+			name: "axes in order of declaration",
+			matrix: `
+os: [linux, darwin]
+arch: [amd64, arm64]`,
+			want: []string{
+				"build[os=linux,arch=amd64]", "build[os=linux,arch=arm64]",
+				"build[os=darwin,arch=amd64]", "build[os=darwin,arch=arm64]",
+			},
+		},
+		{
+			name: "values are strings",
+			matrix: `
+version: [8, 11, true]`,
+			want: []string{"build[version=8]", "build[version=11]", "build[version=true]"},
+		},
+		{
+			name: "exclude partial combination",
+			matrix: `
+os: [linux, darwin]
+arch: [amd64, arm64]
+exclude:
+  - os: darwin`,
+			want: []string{"build[os=linux,arch=amd64]", "build[os=linux,arch=arm64]"},
+		},
+		{
+			name: "include new combination",
+			matrix: `
+go: ["1.16"]
+include:
+  - go: "1.15"
+    cgo: "1"`,
+			want: []string{"build[go=1.16]", "build[go=1.15,cgo=1]"},
+		},
+		{
+			name: "include existing combination once",
+			matrix: `
+go: ["1.16", "1.17"]
+include:
+  - go: "1.17"`,
+			want: []string{"build[go=1.16]", "build[go=1.17]"},
+		},
+		{
+			name: "include only",
+			matrix: `
+include:
+  - go: "1.15"`,
+			want: []string{"build[go=1.15]"},
+		},
+		{
+			name: "everything excluded",
+			matrix: `
+go: ["1.16"]
+exclude:
+  - go: "1.16"`,
+			want: []string{},
+		},
+		{
+			name:   "empty axis",
+			matrix: `go: []`,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m MatrixConfig
+			if err := yaml.Unmarshal([]byte(tt.matrix), &m); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0)
+			for _, c := range m.Combinations() {
+				got = append(got, m.Id("build", c))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatrixAxisMustBeList(t *testing.T) {
+	var m MatrixConfig
+	err := yaml.Unmarshal([]byte(`go: "1.16"`), &m)
+	if err == nil || err.Error() != "matrix axis go must be a list of values" {
+		t.Fatalf("got error %v, want axis to be rejected", err)
+	}
+}
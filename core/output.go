@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,12 +14,42 @@ import (
 var tmpDir = filepath.Join("/tmp", "vulcan")
 var variableDSN = filepath.Join(tmpDir, "database")
 
+// OutputEnv names the variable holding location of the database where steps
+// set their outputs, variableDSN when it is not set.
+const OutputEnv = "VULCAN_OUTPUT_DB"
+
 func CreateTmpDir() error {
 	return os.MkdirAll(tmpDir, 0755)
 }
 
+// UseOwnOutputs makes steps of this process, and the processes it starts, set
+// their outputs in a database of their own, so that jobs sharing temporary
+// directory do not clobber each other. The returned func removes database.
+func UseOwnOutputs() (func(), error) {
+	f, err := ioutil.TempFile(tmpDir, "database-")
+	if err != nil {
+		return nil, err
+	}
+	_ = f.Close()
+	err = os.Setenv(OutputEnv, f.Name())
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return func() {
+		_ = os.Remove(f.Name())
+	}, nil
+}
+
+func outputDSN() string {
+	if dsn := strings.TrimSpace(os.Getenv(OutputEnv)); dsn != "" {
+		return dsn
+	}
+	return variableDSN
+}
+
 func SetCurrentStep(stepId string) error {
-	db, err := bolt.Open(variableDSN, 0755, &bolt.Options{
+	db, err := bolt.Open(outputDSN(), 0755, &bolt.Options{
 		Timeout: 1 * time.Second,
 	})
 	if err != nil {
@@ -46,7 +77,7 @@ func SetCurrentStep(stepId string) error {
 }
 
 func SetOutput(key, value string) error {
-	db, err := bolt.Open(variableDSN, 0755, &bolt.Options{
+	db, err := bolt.Open(outputDSN(), 0755, &bolt.Options{
 		Timeout: 1 * time.Second,
 	})
 	if err != nil {
@@ -95,7 +126,7 @@ type OutputVal struct {
 
 func GetAllOutputs() ([]OutputVal, error) {
 	var outputs []OutputVal
-	db, err := bolt.Open(variableDSN, 0755, &bolt.Options{
+	db, err := bolt.Open(outputDSN(), 0755, &bolt.Options{
 		Timeout: 1 * time.Second,
 	})
 	if err != nil {
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// StepReport is the result of a step run by vexec.
+type StepReport struct {
+	Id       string    `json:"id,omitempty"`
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	ExitCode int       `json:"exit_code"`
+	Attempts int       `json:"attempts,omitempty"`
+	Commands []string  `json:"commands,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
+
+// JobReport is written by vexec once a job is done, so that the runner
+// knows what happened inside the container.
+type JobReport struct {
+	Steps []StepReport `json:"steps"`
+}
+
+func WriteJobReport(file string, r JobReport) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+func ReadJobReport(file string) (JobReport, error) {
+	var r JobReport
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(data, &r)
+	return r, err
+}
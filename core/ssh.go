@@ -0,0 +1,223 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultDockerRemoteSock is the socket of docker daemon on a host reached by
+// ssh, when the address does not give one.
+const DefaultDockerRemoteSock = "/var/run/docker.sock"
+
+// sshTimeout bounds the time connecting and logging in a host by ssh.
+const sshTimeout = 30 * time.Second
+
+// sshTunnel forwards connections to docker daemon through a single ssh
+// connection, made on first use and made again once it is broken.
+type sshTunnel struct {
+	mu     sync.Mutex
+	addr   string
+	socket string
+	config *ssh.ClientConfig
+	agent  net.Conn
+	client *ssh.Client
+}
+
+// newSSHTunnel returns the tunnel of an address such as
+// ssh://user@host:port/var/run/docker.sock. User is the current one and port
+// is 22 when they are omitted.
+func newSSHTunnel(address string, c *DockerSSHConfig) (*sshTunnel, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("host is missing")
+	}
+	if c == nil {
+		c = &DockerSSHConfig{}
+	}
+	t := &sshTunnel{
+		addr:   net.JoinHostPort(u.Hostname(), "22"),
+		socket: DefaultDockerRemoteSock,
+	}
+	if u.Port() != "" {
+		t.addr = net.JoinHostPort(u.Hostname(), u.Port())
+	}
+	if u.Path != "" && u.Path != "/" {
+		t.socket = u.Path
+	}
+	name := u.User.Username()
+	if name == "" {
+		if current, err := user.Current(); err == nil {
+			name = current.Username
+		} else {
+			name = os.Getenv("USER")
+		}
+	}
+
+	hostKeyCallback, err := knownHostsCallback(c.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := t.authMethods(c)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.config = &ssh.ClientConfig{
+		User:            name,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshTimeout,
+	}
+	return t, nil
+}
+
+// knownHostsCallback checks keys of hosts against file, ~/.ssh/known_hosts
+// when it is empty.
+func knownHostsCallback(file string) (ssh.HostKeyCallback, error) {
+	if file = strings.TrimSpace(file); file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(expandHome(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %v", err)
+	}
+	return callback, nil
+}
+
+// authMethods returns keys of ssh-agent, when SSH_AUTH_SOCK is set, then key
+// file of c. Without both, default key files of ~/.ssh are tried.
+func (t *sshTunnel) authMethods(c *DockerSSHConfig) ([]ssh.AuthMethod, error) {
+	methods := make([]ssh.AuthMethod, 0)
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			t.agent = conn
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	files := make([]string, 0)
+	if key := strings.TrimSpace(c.Key); key != "" {
+		files = append(files, expandHome(key))
+	} else if len(methods) == 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+				file := filepath.Join(home, ".ssh", name)
+				if _, err := os.Stat(file); err == nil {
+					files = append(files, file)
+				}
+			}
+		}
+	}
+	signers := make([]ssh.Signer, 0)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh key: %v", err)
+		}
+		var signer ssh.Signer
+		if passphrase := ReadEnvVariableIfHas(c.Passphrase); passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh key %s: %v", file, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh key is found, neither ssh-agent is running")
+	}
+	return methods, nil
+}
+
+// expandHome replaces a leading ~ of path by home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// DialContext connects docker daemon, whatever network and addr are.
+func (t *sshTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if t.client == nil {
+			client, err := t.connect(ctx)
+			if err != nil {
+				return nil, err
+			}
+			t.client = client
+		}
+		conn, err := t.client.Dial("unix", t.socket)
+		if err == nil {
+			return conn, nil
+		}
+		//a broken connection is made again once
+		_ = t.client.Close()
+		t.client = nil
+		if attempt > 0 {
+			return nil, fmt.Errorf("failed to connect %s through ssh: %v", t.socket, err)
+		}
+	}
+}
+
+func (t *sshTunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	d := net.Dialer{Timeout: sshTimeout}
+	conn, err := d.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+	//handshake must not hang on a host which does not answer
+	_ = conn.SetDeadline(time.Now().Add(sshTimeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.addr, t.config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func (t *sshTunnel) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		_ = t.client.Close()
+		t.client = nil
+	}
+	if t.agent != nil {
+		_ = t.agent.Close()
+		t.agent = nil
+	}
+}
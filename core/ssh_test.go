@@ -0,0 +1,251 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshd stands in for sshd of a docker host: it logs in a single key and
+// forwards unix sockets as OpenSSH does for ssh -L.
+type sshd struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mu    sync.Mutex
+	users []string
+	conns []net.Conn
+}
+
+func newSSHKey(t *testing.T) (*ecdsa.PrivateKey, ssh.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, signer
+}
+
+func startSSHD(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) *sshd {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sshd{listener: listener}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, fmt.Errorf("unknown key")
+			}
+			s.mu.Lock()
+			s.users = append(s.users, c.User())
+			s.mu.Unlock()
+			return nil, nil
+		},
+	}
+	s.config.AddHostKey(hostKey)
+	go s.serve()
+	return s
+}
+
+func (s *sshd) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *sshd) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for ch := range chans {
+		if ch.ChannelType() != "direct-streamlocal@openssh.com" {
+			_ = ch.Reject(ssh.UnknownChannelType, "unsupported channel")
+			continue
+		}
+		var target struct {
+			SocketPath string
+			Reserved0  string
+			Reserved1  uint32
+		}
+		if err := ssh.Unmarshal(ch.ExtraData(), &target); err != nil {
+			_ = ch.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		sock, err := net.Dial("unix", target.SocketPath)
+		if err != nil {
+			_ = ch.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, creqs, err := ch.Accept()
+		if err != nil {
+			_ = sock.Close()
+			continue
+		}
+		go ssh.DiscardRequests(creqs)
+		go func() {
+			_, _ = io.Copy(channel, sock)
+			_ = channel.CloseWrite()
+		}()
+		go func() {
+			_, _ = io.Copy(sock, channel)
+			_ = sock.Close()
+		}()
+	}
+}
+
+// dropConnections breaks ssh connections made so far, as a restarted host
+// would.
+func (s *sshd) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *sshd) Close() {
+	_ = s.listener.Close()
+	s.dropConnections()
+}
+
+// writeSSHFiles writes key of client and known hosts telling addr has key.
+func writeSSHFiles(t *testing.T, dir string, clientKey *ecdsa.PrivateKey, addr string, key ssh.PublicKey) (string, string) {
+	der, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ecdsa")
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	if err = ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile, knownHosts
+}
+
+func TestDockerHostThroughSSH(t *testing.T) {
+	if sock, ok := os.LookupEnv("SSH_AUTH_SOCK"); ok {
+		os.Unsetenv("SSH_AUTH_SOCK")
+		defer os.Setenv("SSH_AUTH_SOCK", sock)
+	}
+	//unix sockets have a short path limit, so the directory is kept short
+	dir, err := ioutil.TempDir("", "vssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//docker daemon listening on a unix socket of host
+	socket := filepath.Join(dir, "docker.sock")
+	unixListener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ID":"remote-daemon","NCPU":8}`))
+	}))
+	daemon.Listener = unixListener
+	daemon.Start()
+	defer daemon.Close()
+
+	_, hostKey := newSSHKey(t)
+	clientKey, clientSigner := newSSHKey(t)
+	server := startSSHD(t, hostKey, clientSigner.PublicKey())
+	defer server.Close()
+	addr := server.listener.Addr().String()
+
+	keyFile, knownHosts := writeSSHFiles(t, dir, clientKey, addr, hostKey.PublicKey())
+
+	cli, err := NewDockerClient(DockerHost{
+		Address: "ssh://builder@" + addr + socket,
+		SSH:     &DockerSSHConfig{Key: keyFile, KnownHosts: knownHosts},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	info, err := cli.Client.Info(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "remote-daemon" || info.NCPU != 8 {
+		t.Fatalf("got info %+v of another daemon", info)
+	}
+
+	//a broken connection is made again
+	server.dropConnections()
+	cli.Client.HTTPClient().CloseIdleConnections()
+	if _, err = cli.Client.Info(context.Background()); err != nil {
+		t.Fatalf("tunnel is not made again: %v", err)
+	}
+	server.mu.Lock()
+	users := server.users
+	server.mu.Unlock()
+	if len(users) != 2 || users[0] != "builder" {
+		t.Fatalf("got logins %v, want builder twice", users)
+	}
+}
+
+func TestSSHHostKeyIsChecked(t *testing.T) {
+	if sock, ok := os.LookupEnv("SSH_AUTH_SOCK"); ok {
+		os.Unsetenv("SSH_AUTH_SOCK")
+		defer os.Setenv("SSH_AUTH_SOCK", sock)
+	}
+	dir := t.TempDir()
+	_, hostKey := newSSHKey(t)
+	_, otherKey := newSSHKey(t)
+	clientKey, clientSigner := newSSHKey(t)
+	server := startSSHD(t, hostKey, clientSigner.PublicKey())
+	defer server.Close()
+	addr := server.listener.Addr().String()
+
+	keyFile, knownHosts := writeSSHFiles(t, dir, clientKey, addr, otherKey.PublicKey())
+
+	cli, err := NewDockerClient(DockerHost{
+		Address: "ssh://builder@" + addr,
+		SSH:     &DockerSSHConfig{Key: keyFile, KnownHosts: knownHosts},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	_, err = cli.Client.Info(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "key mismatch") {
+		t.Fatalf("host with another key must be refused, got %v", err)
+	}
+}
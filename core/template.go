@@ -0,0 +1,245 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LoadProjectConfig reads configFile then replaces every job extending a
+// template by the result of merging the job into the template.
+//
+// A job refers to its template with extends, either as <file>#<job-id>, as
+// <file> when the template has the same id as the job, or as <job-id> when
+// the template is a job of one of the files listed in include. Files are
+// looked up relative to the file refering to them, then in templateDir.
+//
+// Values set in the job, even empty or false, override values of the
+// template, except that maps such as args, with and env are merged, and steps
+// are appended unless they have the same id as a step of the template, in
+// which case both steps are merged.
+func LoadProjectConfig(configFile, templateDir string) (ProjectConfig, error) {
+	c, err := ReadProjectConfig(configFile)
+	if err != nil {
+		return c, err
+	}
+	l := &templateLoader{
+		templateDir: templateDir,
+		files:       make(map[string]ProjectConfig),
+	}
+	for id, job := range c.Jobs {
+		job.Id = strings.TrimSpace(id)
+		resolved, err := l.resolve(job, filepath.Dir(configFile), c.Include, nil)
+		if err != nil {
+			return c, fmt.Errorf(`job %s: %v`, job.Id, err)
+		}
+		c.Jobs[id] = resolved
+	}
+	//templates are not needed anymore once they are resolved
+	c.Include = nil
+	return c, nil
+}
+
+// Render returns configuration in yaml format.
+func (c ProjectConfig) Render() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+type templateLoader struct {
+	templateDir string
+	files       map[string]ProjectConfig
+}
+
+func (l *templateLoader) load(file, dir string) (ProjectConfig, string, error) {
+	candidates := []string{file}
+	if !filepath.IsAbs(file) {
+		candidates = []string{filepath.Join(dir, file)}
+		if l.templateDir != "" {
+			candidates = append(candidates, filepath.Join(l.templateDir, file))
+		}
+	}
+	for _, p := range candidates {
+		p = filepath.Clean(p)
+		if c, ok := l.files[p]; ok {
+			return c, p, nil
+		}
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		c, err := ReadProjectConfig(p)
+		if err != nil {
+			return c, p, fmt.Errorf(`template %s: %v`, p, err)
+		}
+		l.files[p] = c
+		return c, p, nil
+	}
+	return ProjectConfig{}, "", fmt.Errorf(`template file %s not found`, file)
+}
+
+// resolve returns job merged into its template. stack holds templates being
+// resolved, to detect jobs extending each other.
+func (l *templateLoader) resolve(job *JobConfig, dir string, includes []string, stack []string) (*JobConfig, error) {
+	ref := strings.TrimSpace(job.Extends)
+	if ref == "" {
+		return job, nil
+	}
+
+	file, id := "", ref
+	if i := strings.Index(ref, "#"); i >= 0 {
+		file, id = strings.TrimSpace(ref[:i]), strings.TrimSpace(ref[i+1:])
+	} else if ext := filepath.Ext(ref); ext == ".yaml" || ext == ".yml" {
+		file, id = ref, job.Id
+	}
+
+	var files []string
+	if file != "" {
+		files = []string{file}
+	} else {
+		files = includes
+	}
+
+	var base *JobConfig
+	var baseFile ProjectConfig
+	basePath := ""
+	for _, f := range files {
+		c, p, err := l.load(f, dir)
+		if err != nil {
+			return nil, err
+		}
+		if j, ok := c.Jobs[id]; ok {
+			base, baseFile, basePath = j.Clone(), c, p
+			break
+		}
+	}
+	if base == nil {
+		return nil, fmt.Errorf(`template job %s not found`, ref)
+	}
+
+	key := fmt.Sprintf(`%s#%s`, basePath, id)
+	for _, k := range stack {
+		if k == key {
+			return nil, fmt.Errorf(`template %s extends itself`, key)
+		}
+	}
+	base.Id = id
+	base, err := l.resolve(base, filepath.Dir(basePath), baseFile.Include, append(stack, key))
+	if err != nil {
+		return nil, err
+	}
+
+	merged := base.Clone()
+	child := job.Clone()
+	child.Extends = ""
+	mergeConfig(reflect.ValueOf(merged).Elem(), reflect.ValueOf(child).Elem(), child.keys)
+	merged.Id = job.Id
+	merged.Extends = ""
+	return merged, nil
+}
+
+// UnmarshalYAML records keys set in yaml, so that a job may override values
+// of its template with empty or false ones.
+func (j *JobConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain JobConfig
+	if err := unmarshal((*plain)(j)); err != nil {
+		return err
+	}
+	keys, err := yamlKeys(unmarshal)
+	j.keys = keys
+	return err
+}
+
+// UnmarshalYAML records keys set in yaml, so that a step may override values
+// of the step of template with the same id with empty or false ones.
+func (s *StepConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain StepConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	keys, err := yamlKeys(unmarshal)
+	s.keys = keys
+	return err
+}
+
+func yamlKeys(unmarshal func(interface{}) error) (map[string]bool, error) {
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(m))
+	for k := range m {
+		keys[strings.TrimSpace(k)] = true
+	}
+	return keys, nil
+}
+
+// mergeConfig overrides fields of base, a job or a step, by the ones set in
+// child: those whose key is in keys, or which are not zero when keys is nil.
+func mergeConfig(base, child reflect.Value, keys map[string]bool) {
+	t := base.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if f.PkgPath != "" || key == "-" {
+			continue
+		}
+		bf, cf := base.Field(i), child.Field(i)
+		if keys != nil && !keys[key] || keys == nil && cf.IsZero() {
+			continue
+		}
+		switch {
+		case f.Name == "Steps":
+			bf.Set(reflect.ValueOf(mergeSteps(bf.Interface().([]StepConfig), cf.Interface().([]StepConfig))))
+		case cf.Kind() == reflect.Map:
+			bf.Set(mergeMap(bf, cf))
+		case cf.Kind() == reflect.Ptr && !cf.IsNil() && cf.Elem().Kind() == reflect.Map:
+			m := reflect.New(cf.Elem().Type())
+			if bf.IsNil() {
+				m.Elem().Set(mergeMap(reflect.Zero(cf.Elem().Type()), cf.Elem()))
+			} else {
+				m.Elem().Set(mergeMap(bf.Elem(), cf.Elem()))
+			}
+			bf.Set(m)
+		default:
+			bf.Set(cf)
+		}
+	}
+}
+
+func mergeMap(base, child reflect.Value) reflect.Value {
+	m := reflect.MakeMap(child.Type())
+	for _, k := range base.MapKeys() {
+		m.SetMapIndex(k, base.MapIndex(k))
+	}
+	for _, k := range child.MapKeys() {
+		m.SetMapIndex(k, child.MapIndex(k))
+	}
+	return m
+}
+
+func mergeSteps(base, child []StepConfig) []StepConfig {
+	steps := append([]StepConfig(nil), base...)
+	for _, step := range child {
+		merged := false
+		if id := strings.TrimSpace(step.Id); id != "" {
+			for i := range steps {
+				if strings.TrimSpace(steps[i].Id) != id {
+					continue
+				}
+				if strings.TrimSpace(step.Run) != "" || strings.TrimSpace(step.Use) != "" {
+					steps[i].Run, steps[i].Use = "", ""
+				}
+				mergeConfig(reflect.ValueOf(&steps[i]).Elem(), reflect.ValueOf(step), step.keys)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
@@ -0,0 +1,268 @@
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const templateFile = `jobs:
+  build:
+    run-on: golang:1.16
+    timeout: 1h
+    shell: bash
+    if: always()
+    env:
+      GOOS: linux
+      CGO_ENABLED: "0"
+    steps:
+      - id: checkout
+        run: git clone .
+        timeout: 10m
+        continue-on-error: true
+      - id: compile
+        run: go build ./...
+  release:
+    extends: templates.yaml#build
+    steps:
+      - run: goreleaser
+`
+
+func TestLoadProjectConfigWithTemplates(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name: "extends job of file",
+			config: `jobs:
+  ci:
+    extends: templates.yaml#build
+    env:
+      GOOS: windows
+    steps:
+      - run: go test ./...
+`,
+			want: `run-on: golang:1.16
+if: always()
+timeout: 1h
+shell: bash
+env:
+  CGO_ENABLED: "0"
+  GOOS: windows
+steps:
+- id: checkout
+  run: git clone .
+  timeout: 10m
+  continue-on-error: true
+- id: compile
+  run: go build ./...
+- run: go test ./...
+`,
+		},
+		{
+			name: "extends job of file with the same id",
+			config: `jobs:
+  build:
+    extends: templates.yaml
+    run-on: golang:1.17
+`,
+			want: `run-on: golang:1.17
+if: always()
+timeout: 1h
+shell: bash
+env:
+  CGO_ENABLED: "0"
+  GOOS: linux
+steps:
+- id: checkout
+  run: git clone .
+  timeout: 10m
+  continue-on-error: true
+- id: compile
+  run: go build ./...
+`,
+		},
+		{
+			name: "extends job of included file extending another one",
+			config: `include: [templates.yaml]
+jobs:
+  ci:
+    extends: release
+`,
+			want: `run-on: golang:1.16
+if: always()
+timeout: 1h
+shell: bash
+env:
+  CGO_ENABLED: "0"
+  GOOS: linux
+steps:
+- id: checkout
+  run: git clone .
+  timeout: 10m
+  continue-on-error: true
+- id: compile
+  run: go build ./...
+- run: goreleaser
+`,
+		},
+		{
+			name: "steps with the same id are merged",
+			config: `jobs:
+  ci:
+    extends: templates.yaml#build
+    steps:
+      - id: checkout
+        use: checkout
+        with:
+          depth: "1"
+      - id: compile
+        if: success()
+`,
+			want: `run-on: golang:1.16
+if: always()
+timeout: 1h
+shell: bash
+env:
+  CGO_ENABLED: "0"
+  GOOS: linux
+steps:
+- id: checkout
+  use: checkout
+  timeout: 10m
+  continue-on-error: true
+  with:
+    depth: "1"
+- id: compile
+  if: success()
+  run: go build ./...
+`,
+		},
+		{
+			name: "empty and false values override template",
+			config: `jobs:
+  ci:
+    extends: templates.yaml#build
+    if: ""
+    shell: ""
+    steps:
+      - id: checkout
+        timeout: ""
+        continue-on-error: false
+`,
+			want: `run-on: golang:1.16
+timeout: 1h
+env:
+  CGO_ENABLED: "0"
+  GOOS: linux
+steps:
+- id: checkout
+  run: git clone .
+- id: compile
+  run: go build ./...
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(dir, "templates.yaml"), []byte(templateFile), 0644); err != nil {
+				t.Fatal(err)
+			}
+			configFile := filepath.Join(dir, "ci.yaml")
+			if err := ioutil.WriteFile(configFile, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			c, err := LoadProjectConfig(configFile, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Include != nil {
+				t.Errorf("include is kept once templates are resolved: %v", c.Include)
+			}
+			if len(c.Jobs) != 1 {
+				t.Fatalf("got %d jobs, want 1", len(c.Jobs))
+			}
+			for _, job := range c.Jobs {
+				got, err := yaml.Marshal(job)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tt.want {
+					t.Fatalf("got job\n%s\nwant\n%s", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadProjectConfigWithTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "missing file",
+			config:  "jobs:\n  ci:\n    extends: missing.yaml#build\n",
+			wantErr: "job ci: template file missing.yaml not found",
+		},
+		{
+			name:    "missing job",
+			config:  "jobs:\n  ci:\n    extends: templates.yaml#deploy\n",
+			wantErr: "job ci: template job templates.yaml#deploy not found",
+		},
+		{
+			name:    "job not included",
+			config:  "jobs:\n  ci:\n    extends: build\n",
+			wantErr: "job ci: template job build not found",
+		},
+		{
+			name:    "jobs extending each other",
+			config:  "jobs:\n  ci:\n    extends: ci.yaml#lint\n  lint:\n    extends: ci.yaml#test\n  test:\n    extends: ci.yaml#lint\n",
+			wantErr: "extends itself",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(dir, "templates.yaml"), []byte(templateFile), 0644); err != nil {
+				t.Fatal(err)
+			}
+			configFile := filepath.Join(dir, "ci.yaml")
+			if err := ioutil.WriteFile(configFile, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadProjectConfig(configFile, "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTemplateIsLookedUpInTemplateDir(t *testing.T) {
+	dir, templateDir := t.TempDir(), t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(templateDir, "templates.yaml"), []byte(templateFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "ci.yaml")
+	if err := ioutil.WriteFile(configFile, []byte("jobs:\n  build:\n    extends: templates.yaml\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadProjectConfig(configFile, ""); err == nil {
+		t.Fatalf("template must not be found without template dir")
+	}
+	c, err := LoadProjectConfig(configFile, templateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := c.Jobs["build"]; job.RunOn != "golang:1.16" || len(job.Steps) != 2 {
+		t.Fatalf("got job %+v, want the one of template", job)
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	EventPush        = "push"
+	EventTag         = "tag"
+	EventPullRequest = "pull_request"
+)
+
+// Trigger is a change of a repository which may run actions.
+type Trigger struct {
+	Event string
+	// Ref is the full name of the changed reference, e.g. refs/heads/main.
+	Ref    string
+	Commit string
+	// Branch is the pushed branch, or the branch a pull request is merged
+	// into.
+	Branch string
+	Tag    string
+	// PullRequest is the number of pull request.
+	PullRequest string
+}
+
+func (t Trigger) String() string {
+	return fmt.Sprintf("%s %s (%s)", t.Event, t.Ref, t.Commit)
+}
+
+// Env returns variables describing trigger to jobs.
+func (t Trigger) Env() []string {
+	envs := []string{
+		fmt.Sprintf("VULCAN_EVENT=%s", t.Event),
+		fmt.Sprintf("VULCAN_REF=%s", t.Ref),
+		fmt.Sprintf("VULCAN_COMMIT=%s", t.Commit),
+	}
+	if t.Branch != "" {
+		envs = append(envs, fmt.Sprintf("VULCAN_BRANCH=%s", t.Branch))
+	}
+	if t.Tag != "" {
+		envs = append(envs, fmt.Sprintf("VULCAN_TAG=%s", t.Tag))
+	}
+	if t.PullRequest != "" {
+		envs = append(envs, fmt.Sprintf("VULCAN_PULL_REQUEST=%s", t.PullRequest))
+	}
+	return envs
+}
+
+// Match tells whether trigger runs the action. Event defaults to push when
+// it is not set. Branch and tag are lists of globs where * matches any
+// characters but / and ** matches any characters; empty matches everything.
+// An action without on is never triggered.
+func (o *OnConfig) Match(t Trigger) bool {
+	if o == nil {
+		return false
+	}
+	events := o.Event
+	if len(events) == 0 {
+		events = []string{EventPush}
+	}
+	found := false
+	for _, e := range events {
+		if strings.TrimSpace(e) == t.Event {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	switch t.Event {
+	case EventTag:
+		return matchAnyGlob(o.Tag, t.Tag)
+	default:
+		return matchAnyGlob(o.Branch, t.Branch)
+	}
+}
+
+func matchAnyGlob(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if MatchGlob(strings.TrimSpace(p), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob tells whether s matches pattern, where * matches any characters
+// but / and ** matches any characters.
+func MatchGlob(pattern, s string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
@@ -0,0 +1,58 @@
+package core
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"main", "main", true},
+		{"main", "main2", false},
+		{"release/*", "release/1.0", true},
+		{"release/*", "release/1.0/hotfix", false},
+		{"release/**", "release/1.0/hotfix", true},
+		{"**", "feature/a/b", true},
+		{"*", "feature/a", false},
+		{"v?.0", "v1.0", true},
+		{"v?.0", "v10.0", false},
+		{"v1.*", "v1.2.3", true},
+		{"v1.*", "v1x2", false},
+		{"feature-(a)", "feature-(a)", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestOnConfigMatch(t *testing.T) {
+	push := Trigger{Event: EventPush, Ref: "refs/heads/release/1.0", Branch: "release/1.0"}
+	tag := Trigger{Event: EventTag, Ref: "refs/tags/v1.0.0", Tag: "v1.0.0"}
+	pr := Trigger{Event: EventPullRequest, Ref: "refs/pull/3/head", Branch: "main", PullRequest: "3"}
+	tests := []struct {
+		name    string
+		on      *OnConfig
+		trigger Trigger
+		want    bool
+	}{
+		{"no on", nil, push, false},
+		{"push by default", &OnConfig{}, push, true},
+		{"not tag by default", &OnConfig{}, tag, false},
+		{"branch glob", &OnConfig{Branch: []string{"main", "release/*"}}, push, true},
+		{"other branch", &OnConfig{Branch: []string{"main"}}, push, false},
+		{"tag glob", &OnConfig{Event: []string{EventTag}, Tag: []string{"v*"}}, tag, true},
+		{"tag ignores branch", &OnConfig{Event: []string{EventTag}, Branch: []string{"main"}}, tag, true},
+		{"other tag", &OnConfig{Event: []string{EventTag}, Tag: []string{"release-*"}}, tag, false},
+		{"pull request into branch", &OnConfig{Event: []string{EventPush, EventPullRequest}, Branch: []string{"main"}}, pr, true},
+		{"pull request not listened", &OnConfig{Branch: []string{"main"}}, pr, false},
+	}
+	for _, tt := range tests {
+		if got := tt.on.Match(tt.trigger); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
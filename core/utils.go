@@ -2,11 +2,14 @@ package core
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/md5"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"text/template"
+	"time"
 )
 
 func ReadEnvVariableIfHas(str string) string {
@@ -73,3 +76,38 @@ func SumContentMD5(file string) (string, error) {
 	}
 	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
 }
+
+// RenderTemplate executes text as a text/template against data. Text without
+// any action is returned as it is.
+func RenderTemplate(text string, data interface{}) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	t, err := template.New("tmpl").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ParseDuration parses durations such as 90s or 1h30m. An empty string is a
+// zero duration, which means no limit.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf(`duration %s is negative`, s)
+	}
+	return d, nil
+}
@@ -0,0 +1,760 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Diagnostic is a problem found in a configuration file.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+type validator struct {
+	file        string
+	diagnostics []Diagnostic
+	includes    []string
+	templates   *templateLoader
+}
+
+// ValidateProjectConfig strictly checks configFile against the schema of
+// ProjectConfig, then checks the meaning of what is configured. Unlike
+// ReadProjectConfig, unknown keys are reported instead of being ignored.
+// Templates extended by jobs are looked up as in LoadProjectConfig.
+func ValidateProjectConfig(configFile, templateDir string) ([]Diagnostic, error) {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("read application config file get error %v", err)
+	}
+
+	v := &validator{
+		file: configFile,
+		templates: &templateLoader{
+			templateDir: templateDir,
+			files:       make(map[string]ProjectConfig),
+		},
+	}
+	var doc yamlv3.Node
+	err = yamlv3.Unmarshal(data, &doc)
+	if err != nil {
+		line := 1
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		v.diagnostics = append(v.diagnostics, Diagnostic{
+			File:    configFile,
+			Line:    line,
+			Column:  1,
+			Message: strings.TrimPrefix(err.Error(), "yaml: "),
+		})
+		return v.diagnostics, nil
+	}
+	if len(doc.Content) == 0 {
+		v.diagnostics = append(v.diagnostics, Diagnostic{
+			File:    configFile,
+			Line:    1,
+			Column:  1,
+			Message: "configuration is empty",
+		})
+		return v.diagnostics, nil
+	}
+
+	root := resolveAlias(doc.Content[0])
+	v.checkSchema(root, reflect.TypeOf(ProjectConfig{}), "")
+	if root.Kind == yamlv3.MappingNode {
+		if _, include := mappingValue(root, "include"); include != nil && include.Kind == yamlv3.SequenceNode {
+			for _, n := range include.Content {
+				v.includes = append(v.includes, strings.TrimSpace(n.Value))
+			}
+		}
+		v.checkProject(root)
+	}
+	sort.SliceStable(v.diagnostics, func(i, j int) bool {
+		if v.diagnostics[i].Line != v.diagnostics[j].Line {
+			return v.diagnostics[i].Line < v.diagnostics[j].Line
+		}
+		return v.diagnostics[i].Column < v.diagnostics[j].Column
+	})
+	return v.diagnostics, nil
+}
+
+func (v *validator) report(n *yamlv3.Node, format string, a ...interface{}) {
+	v.diagnostics = append(v.diagnostics, Diagnostic{
+		File:    v.file,
+		Line:    n.Line,
+		Column:  n.Column,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (v *validator) expectKind(n *yamlv3.Node, kind yamlv3.Kind, path string) bool {
+	if n.Kind == kind {
+		return true
+	}
+	name := path
+	if name == "" {
+		name = "configuration"
+	}
+	v.report(n, "%s must be %s, got %s", name, kindName(kind), kindName(n.Kind))
+	return false
+}
+
+func (v *validator) checkSchema(n *yamlv3.Node, t reflect.Type, path string) {
+	n = resolveAlias(n)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if n.Kind == yamlv3.ScalarNode && n.Tag == "!!null" {
+		return
+	}
+	//jobs and steps only record their keys when they are unmarshalled, other
+	//types unmarshalling themselves may have another shape
+	custom := t != reflect.TypeOf(JobConfig{}) && t != reflect.TypeOf(StepConfig{})
+	if custom && reflect.PtrTo(t).Implements(yamlUnmarshalerType) {
+		if t == reflect.TypeOf(MatrixConfig{}) {
+			v.checkMatrix(n, path)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if !v.expectKind(n, yamlv3.MappingNode, path) {
+			return
+		}
+		fields := yamlFields(t)
+		seen := make(map[string]struct{})
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, val := n.Content[i], n.Content[i+1]
+			if _, ok := seen[k.Value]; ok {
+				v.report(k, "duplicate key %q", k.Value)
+			}
+			seen[k.Value] = struct{}{}
+			ft, ok := fields[k.Value]
+			if !ok {
+				where := ""
+				if path != "" {
+					where = " in " + path
+				}
+				v.report(k, "unknown key %q%s%s", k.Value, where, suggestKey(k.Value, fields))
+				continue
+			}
+			v.checkSchema(val, ft, joinPath(path, k.Value))
+		}
+	case reflect.Map:
+		if !v.expectKind(n, yamlv3.MappingNode, path) {
+			return
+		}
+		seen := make(map[string]struct{})
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, val := n.Content[i], n.Content[i+1]
+			if _, ok := seen[k.Value]; ok {
+				v.report(k, "duplicate key %q", k.Value)
+			}
+			seen[k.Value] = struct{}{}
+			v.checkSchema(val, t.Elem(), joinPath(path, k.Value))
+		}
+	case reflect.Slice:
+		if !v.expectKind(n, yamlv3.SequenceNode, path) {
+			return
+		}
+		for i, item := range n.Content {
+			v.checkSchema(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.String:
+		v.expectKind(n, yamlv3.ScalarNode, path)
+	case reflect.Bool:
+		if v.expectKind(n, yamlv3.ScalarNode, path) && n.Tag != "!!bool" {
+			v.report(n, "%s must be true or false, got %q", path, n.Value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.expectKind(n, yamlv3.ScalarNode, path) && n.Tag != "!!int" {
+			v.report(n, "%s must be a number, got %q", path, n.Value)
+		}
+	}
+}
+
+func (v *validator) checkMatrix(n *yamlv3.Node, path string) {
+	if !v.expectKind(n, yamlv3.MappingNode, path) {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, val := n.Content[i], resolveAlias(n.Content[i+1])
+		p := joinPath(path, k.Value)
+		if !v.expectKind(val, yamlv3.SequenceNode, p) {
+			continue
+		}
+		for j, item := range val.Content {
+			item = resolveAlias(item)
+			itemPath := fmt.Sprintf("%s[%d]", p, j)
+			if k.Value != "include" && k.Value != "exclude" {
+				v.expectKind(item, yamlv3.ScalarNode, itemPath)
+				continue
+			}
+			if !v.expectKind(item, yamlv3.MappingNode, itemPath) {
+				continue
+			}
+			for l := 1; l < len(item.Content); l += 2 {
+				v.expectKind(resolveAlias(item.Content[l]), yamlv3.ScalarNode, joinPath(itemPath, item.Content[l-1].Value))
+			}
+		}
+	}
+}
+
+func (v *validator) checkProject(root *yamlv3.Node) {
+	if _, on := mappingValue(root, "on"); on != nil {
+		if _, events := mappingValue(on, "event"); events != nil && events.Kind == yamlv3.SequenceNode {
+			for _, e := range events.Content {
+				switch strings.TrimSpace(e.Value) {
+				case EventPush, EventTag, EventPullRequest:
+				default:
+					v.report(e, "event must be %s, %s or %s, got %q", EventPush, EventTag, EventPullRequest, e.Value)
+				}
+			}
+		}
+		if _, schedule := mappingValue(on, "schedule"); schedule != nil {
+			_, repeat := mappingValue(schedule, "repeat")
+			if repeat != nil && repeat.Value != RepeatedEveryDay && repeat.Value != RepeatedEveryHour {
+				v.report(repeat, "repeat must be %s or %s, got %q", RepeatedEveryDay, RepeatedEveryHour, repeat.Value)
+			}
+			if _, cron := mappingValue(schedule, "cron"); cron != nil && cron.Kind == yamlv3.ScalarNode {
+				if _, err := ParseCron(cron.Value); err != nil {
+					v.report(cron, "%v", err)
+				}
+			}
+			if _, tz := mappingValue(schedule, "timezone"); tz != nil && tz.Kind == yamlv3.ScalarNode {
+				if _, err := time.LoadLocation(strings.TrimSpace(tz.Value)); err != nil {
+					v.report(tz, "unknown timezone %q", tz.Value)
+				}
+			}
+			if _, max := mappingValue(schedule, "max-concurrent"); max != nil && max.Kind == yamlv3.ScalarNode {
+				if n, err := strconv.Atoi(max.Value); err == nil && n < 0 {
+					v.report(max, "max-concurrent must not be negative")
+				}
+			}
+		}
+	}
+
+	v.checkConcurrency(root, templateScope{args: make(map[string]struct{})})
+
+	_, jobs := mappingValue(root, "jobs")
+	if jobs == nil || (jobs.Kind == yamlv3.MappingNode && len(jobs.Content) == 0) {
+		v.report(root, "no job is defined")
+		return
+	}
+	if jobs.Kind != yamlv3.MappingNode {
+		return
+	}
+
+	ids := make(map[string]struct{})
+	for i := 0; i+1 < len(jobs.Content); i += 2 {
+		ids[strings.TrimSpace(jobs.Content[i].Value)] = struct{}{}
+	}
+
+	graph := make(map[string]*JobConfig)
+	for i := 0; i+1 < len(jobs.Content); i += 2 {
+		k, job := jobs.Content[i], resolveAlias(jobs.Content[i+1])
+		if job.Kind != yamlv3.MappingNode {
+			continue
+		}
+		graph[strings.TrimSpace(k.Value)] = &JobConfig{Needs: v.checkJob(k, job, ids)}
+	}
+
+	_, err := PlanJobs(graph)
+	if err != nil && strings.HasPrefix(err.Error(), "dependency cycle") {
+		v.report(jobs, "%v", err)
+	}
+}
+
+// checkJob checks a job and returns ids of the known jobs it needs.
+func (v *validator) checkJob(key, job *yamlv3.Node, ids map[string]struct{}) []string {
+	id := strings.TrimSpace(key.Value)
+	runOn := ""
+	if _, n := mappingValue(job, "run-on"); n != nil {
+		runOn = strings.TrimSpace(n.Value)
+	}
+	extends := false
+	if _, n := mappingValue(job, "extends"); n != nil && strings.TrimSpace(n.Value) != "" {
+		extends = true
+		resolved, err := v.templates.resolve(&JobConfig{Id: id, Extends: n.Value}, filepath.Dir(v.file), v.includes, nil)
+		if err != nil {
+			v.report(n, "%v", err)
+		} else if runOn == "" && strings.TrimSpace(resolved.RunOn) == "" {
+			v.report(key, "job %q is missing run-on, neither set by its template", id)
+		}
+	} else if runOn == "" {
+		v.report(key, "job %q is missing run-on", id)
+	}
+
+	if _, cond := mappingValue(job, "if"); cond != nil {
+		if err := CheckCondition(cond.Value); err != nil {
+			v.report(cond, "%v", err)
+		}
+	}
+
+	v.checkDuration(job, "timeout")
+	v.checkShell(job)
+	v.checkPull(job)
+
+	var needs []string
+	if _, n := mappingValue(job, "needs"); n != nil && n.Kind == yamlv3.SequenceNode {
+		for _, item := range n.Content {
+			need := strings.TrimSpace(item.Value)
+			if _, ok := ids[need]; !ok {
+				v.report(item, "job %q needs unknown job %q", id, need)
+				continue
+			}
+			needs = append(needs, need)
+		}
+	}
+
+	if _, n := mappingValue(job, "artifacts"); n != nil && n.Kind == yamlv3.SequenceNode {
+		for _, item := range n.Content {
+			parts := strings.Split(item.Value, ":")
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+				v.report(item, "artifact %q must be in form <host-path>:<container-path>", item.Value)
+			}
+		}
+	}
+
+	if _, n := mappingValue(job, "labels"); n != nil && n.Kind == yamlv3.SequenceNode {
+		for _, item := range n.Content {
+			if strings.TrimSpace(item.Value) == "" {
+				v.report(item, "label of job %q must not be empty", id)
+			}
+		}
+	}
+
+	if _, approval := mappingValue(job, "approval"); approval != nil && approval.Kind == yamlv3.MappingNode {
+		v.checkDuration(approval, "timeout")
+		if _, n := mappingValue(approval, "approvers"); n != nil && n.Kind == yamlv3.SequenceNode {
+			for _, item := range n.Content {
+				if strings.TrimSpace(item.Value) == "" {
+					v.report(item, "approver of job %q must not be empty", id)
+				}
+			}
+		}
+	}
+
+	if _, services := mappingValue(job, "services"); services != nil && services.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(services.Content); i += 2 {
+			k, service := services.Content[i], resolveAlias(services.Content[i+1])
+			if service.Kind != yamlv3.MappingNode {
+				continue
+			}
+			if _, image := mappingValue(service, "image"); image == nil || strings.TrimSpace(image.Value) == "" {
+				v.report(k, "service %q is missing image", k.Value)
+			}
+			if _, hc := mappingValue(service, "healthcheck"); hc != nil {
+				v.checkDuration(hc, "interval")
+				v.checkDuration(hc, "timeout")
+			}
+		}
+	}
+
+	scope := templateScope{
+		args:  mappingKeys(job, "args"),
+		steps: make(map[string]struct{}),
+	}
+	if _, m := mappingValue(job, "matrix"); m != nil && m.Kind == yamlv3.MappingNode {
+		scope.matrix = make(map[string]struct{})
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			k, val := m.Content[i].Value, resolveAlias(m.Content[i+1])
+			if k != "include" {
+				scope.matrix[k] = struct{}{}
+				continue
+			}
+			for _, item := range val.Content {
+				for j := 0; j < len(resolveAlias(item).Content); j += 2 {
+					scope.matrix[resolveAlias(item).Content[j].Value] = struct{}{}
+				}
+			}
+		}
+	}
+	if _, runOn := mappingValue(job, "run-on"); runOn != nil {
+		v.checkTemplate(runOn, templateScope{matrix: scope.matrix})
+	}
+	v.checkEnvTemplates(job, scope)
+	v.checkConcurrency(job, scope)
+
+	_, steps := mappingValue(job, "steps")
+	if steps == nil || steps.Kind != yamlv3.SequenceNode {
+		return needs
+	}
+	for _, step := range steps.Content {
+		step = resolveAlias(step)
+		if step.Kind != yamlv3.MappingNode {
+			continue
+		}
+		_, run := mappingValue(step, "run")
+		_, use := mappingValue(step, "use")
+		hasRun := run != nil && strings.TrimSpace(run.Value) != ""
+		hasUse := use != nil && strings.TrimSpace(use.Value) != ""
+		if hasRun && hasUse {
+			v.report(step, "step must specify either run or use, not both")
+		} else if !hasRun && !hasUse && !(extends && stepId(step) != "") {
+			// a step of a job extending a template may only override a step
+			// of the template having the same id
+			v.report(step, "step must specify either run or use")
+		}
+
+		if _, cond := mappingValue(step, "if"); cond != nil {
+			if err := CheckCondition(cond.Value); err != nil {
+				v.report(cond, "%v", err)
+			}
+		}
+		v.checkDuration(step, "timeout")
+		v.checkShell(step)
+		if _, retry := mappingValue(step, "retry"); retry != nil {
+			v.checkDuration(retry, "backoff")
+			if _, attempts := mappingValue(retry, "attempts"); attempts != nil && attempts.Tag == "!!int" {
+				if n, _ := strconv.Atoi(attempts.Value); n < 1 {
+					v.report(attempts, "retry attempts must be at least 1, got %s", attempts.Value)
+				}
+			}
+		}
+
+		stepScope := templateScope{
+			args:   mappingKeys(step, "args"),
+			matrix: scope.matrix,
+			steps:  scope.steps,
+		}
+		for k := range scope.args {
+			stepScope.args[k] = struct{}{}
+		}
+		if hasRun {
+			v.checkTemplate(run, stepScope)
+		}
+		v.checkEnvTemplates(step, stepScope)
+		if _, with := mappingValue(step, "with"); with != nil && with.Kind == yamlv3.MappingNode {
+			for i := 1; i < len(with.Content); i += 2 {
+				v.checkTemplate(resolveAlias(with.Content[i]), stepScope)
+			}
+		}
+
+		if _, stepId := mappingValue(step, "id"); stepId != nil && strings.TrimSpace(stepId.Value) != "" {
+			sid := strings.TrimSpace(stepId.Value)
+			if _, ok := scope.steps[sid]; ok {
+				v.report(stepId, "duplicate step id %q in job %q", sid, id)
+			}
+			scope.steps[sid] = struct{}{}
+		}
+	}
+	return needs
+}
+
+func stepId(step *yamlv3.Node) string {
+	if _, id := mappingValue(step, "id"); id != nil {
+		return strings.TrimSpace(id.Value)
+	}
+	return ""
+}
+
+func (v *validator) checkDuration(n *yamlv3.Node, key string) {
+	_, d := mappingValue(n, key)
+	if d == nil || d.Kind != yamlv3.ScalarNode {
+		return
+	}
+	if _, err := ParseDuration(d.Value); err != nil {
+		v.report(d, "%s must be a duration such as 30s or 1h30m: %v", key, err)
+	}
+}
+
+// checkConcurrency checks concurrency group of a project or a job, which may
+// refer to ConcurrencyVars besides args and matrix of scope.
+func (v *validator) checkConcurrency(n *yamlv3.Node, scope templateScope) {
+	_, c := mappingValue(n, "concurrency")
+	if c == nil || c.Kind != yamlv3.MappingNode {
+		return
+	}
+	_, group := mappingValue(c, "group")
+	if group == nil || strings.TrimSpace(group.Value) == "" {
+		v.report(c, "concurrency group is missing")
+		return
+	}
+	groupScope := templateScope{
+		args:   make(map[string]struct{}),
+		matrix: scope.matrix,
+	}
+	for k := range scope.args {
+		groupScope.args[k] = struct{}{}
+	}
+	for _, k := range ConcurrencyVars {
+		groupScope.args[k] = struct{}{}
+	}
+	v.checkTemplate(group, groupScope)
+}
+
+func (v *validator) checkShell(n *yamlv3.Node) {
+	_, shell := mappingValue(n, "shell")
+	if shell == nil || shell.Kind != yamlv3.ScalarNode {
+		return
+	}
+	switch strings.TrimSpace(shell.Value) {
+	case ShellSh, ShellBash, ShellNone:
+	default:
+		v.report(shell, "shell must be %s, %s or %s, got %q", ShellSh, ShellBash, ShellNone, shell.Value)
+	}
+}
+
+func (v *validator) checkPull(n *yamlv3.Node) {
+	_, pull := mappingValue(n, "pull")
+	if pull == nil || pull.Kind != yamlv3.ScalarNode {
+		return
+	}
+	switch strings.TrimSpace(pull.Value) {
+	case PullAlways, PullIfNotPresent, PullNever:
+	default:
+		v.report(pull, "pull must be %s, %s or %s, got %q", PullAlways, PullIfNotPresent, PullNever, pull.Value)
+	}
+}
+
+// templateScope holds names a template of a step may refer to. matrix is nil
+// if the job does not declare a matrix.
+type templateScope struct {
+	args   map[string]struct{}
+	matrix map[string]struct{}
+	steps  map[string]struct{}
+}
+
+func (s templateScope) defines(ident []string) bool {
+	name := ident[0]
+	if name == "matrix" {
+		if s.matrix == nil {
+			return false
+		}
+		if len(ident) < 2 {
+			return true
+		}
+		_, ok := s.matrix[ident[1]]
+		return ok
+	}
+	if _, ok := s.args[name]; ok {
+		return true
+	}
+	for id := range s.steps {
+		if strings.HasPrefix(name, fmt.Sprintf(`steps_%s_outputs_`, id)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) checkEnvTemplates(n *yamlv3.Node, scope templateScope) {
+	_, env := mappingValue(n, "env")
+	if env == nil || env.Kind != yamlv3.MappingNode {
+		return
+	}
+	for i := 1; i < len(env.Content); i += 2 {
+		v.checkTemplate(resolveAlias(env.Content[i]), scope)
+	}
+}
+
+func (v *validator) checkTemplate(n *yamlv3.Node, scope templateScope) {
+	if n.Kind != yamlv3.ScalarNode || !strings.Contains(n.Value, "{{") {
+		return
+	}
+	t, err := template.New("tmpl").Parse(n.Value)
+	if err != nil {
+		v.report(n, "invalid template: %s", strings.TrimPrefix(err.Error(), "template: "))
+		return
+	}
+	reported := make(map[string]struct{})
+	walkTemplate(t.Tree.Root, func(ident []string) {
+		name := strings.Join(ident, ".")
+		if _, ok := reported[name]; ok || scope.defines(ident) {
+			return
+		}
+		reported[name] = struct{}{}
+		v.report(n, "template references undefined arg %q", name)
+	})
+}
+
+func walkTemplate(node parse.Node, f func(ident []string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkTemplate(c, f)
+		}
+	case *parse.ActionNode:
+		walkTemplate(n.Pipe, f)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			walkTemplate(c, f)
+		}
+	case *parse.CommandNode:
+		for _, c := range n.Args {
+			walkTemplate(c, f)
+		}
+	case *parse.FieldNode:
+		f(n.Ident)
+	case *parse.IfNode:
+		walkTemplate(n.Pipe, f)
+		walkTemplate(n.List, f)
+		walkTemplate(n.ElseList, f)
+	case *parse.RangeNode:
+		walkTemplate(n.Pipe, f)
+	case *parse.WithNode:
+		walkTemplate(n.Pipe, f)
+	case *parse.TemplateNode:
+		walkTemplate(n.Pipe, f)
+	}
+}
+
+// yamlFields maps yaml keys of struct t to type of their field.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		inline := false
+		for _, p := range parts[1:] {
+			if p == "inline" {
+				inline = true
+			}
+		}
+		if inline {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			for k, v := range yamlFields(ft) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func suggestKey(key string, fields map[string]reflect.Type) string {
+	normalized := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+	best, bestDistance := "", 3
+	for name := range fields {
+		if name == normalized {
+			return fmt.Sprintf(` (did you mean "%s"?)`, name)
+		}
+		if d := editDistance(normalized, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	if best == "" || bestDistance > 2 {
+		return ""
+	}
+	return fmt.Sprintf(` (did you mean "%s"?)`, best)
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func resolveAlias(n *yamlv3.Node) *yamlv3.Node {
+	for n.Kind == yamlv3.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+func mappingValue(n *yamlv3.Node, key string) (*yamlv3.Node, *yamlv3.Node) {
+	if n.Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i], resolveAlias(n.Content[i+1])
+		}
+	}
+	return nil, nil
+}
+
+func mappingKeys(n *yamlv3.Node, key string) map[string]struct{} {
+	keys := make(map[string]struct{})
+	_, m := mappingValue(n, key)
+	if m == nil || m.Kind != yamlv3.MappingNode {
+		return keys
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		keys[m.Content[i].Value] = struct{}{}
+	}
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func kindName(kind yamlv3.Kind) string {
+	switch kind {
+	case yamlv3.MappingNode:
+		return "a mapping"
+	case yamlv3.SequenceNode:
+		return "a list"
+	case yamlv3.ScalarNode:
+		return "a single value"
+	}
+	return "a document"
+}
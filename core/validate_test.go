@@ -0,0 +1,185 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateProjectConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name: "valid",
+			config: `on:
+  event: [push, tag]
+  branch: [main]
+jobs:
+  lint:
+    run-on: golangci/golangci-lint
+    steps:
+      - run: golangci-lint run
+  build:
+    run-on: golang:1.16
+    needs: [lint]
+    args:
+      version: 1.0.0
+    steps:
+      - id: compile
+        run: go build -ldflags "-X main.version={{.version}}" ./...
+        timeout: 10m
+        retry:
+          attempts: 2
+`,
+			want: []string{},
+		},
+		{
+			name: "unknown key with suggestion",
+			config: `jobs:
+  build:
+    run-on: golang
+    step:
+      - run: make
+`,
+			want: []string{`4:5: unknown key "step" in jobs.build (did you mean "steps"?)`},
+		},
+		{
+			name: "missing run-on",
+			config: `jobs:
+  build:
+    steps:
+      - run: make
+`,
+			want: []string{`2:3: job "build" is missing run-on`},
+		},
+		{
+			name: "unknown need",
+			config: `jobs:
+  build:
+    run-on: golang
+    needs: [lint]
+    steps:
+      - run: make
+`,
+			want: []string{`4:13: job "build" needs unknown job "lint"`},
+		},
+		{
+			name: "dependency cycle",
+			config: `jobs:
+  a:
+    run-on: alpine
+    needs: [b]
+    steps:
+      - run: a
+  b:
+    run-on: alpine
+    needs: [a]
+    steps:
+      - run: b
+`,
+			want: []string{`2:3: dependency cycle detected: a -> b -> a`},
+		},
+		{
+			name: "invalid condition and steps",
+			config: `jobs:
+  build:
+    run-on: alpine
+    if: success( &&
+    steps:
+      - run: make
+        use: plugin
+      - shell: fish
+        run: make
+      - id: x
+        run: a
+      - id: x
+        run: b
+`,
+			want: []string{
+				`4:9: invalid expression "success( &&": unexpected && at position 10`,
+				`6:9: step must specify either run or use, not both`,
+				`8:16: shell must be sh, bash or none, got "fish"`,
+				`12:13: duplicate step id "x" in job "build"`,
+			},
+		},
+		{
+			name: "invalid duration and undefined arg",
+			config: `jobs:
+  build:
+    run-on: alpine
+    timeout: soon
+    steps:
+      - run: echo {{.missing}}
+`,
+			want: []string{
+				`4:14: timeout must be a duration such as 30s or 1h30m: time: invalid duration "soon"`,
+				`6:14: template references undefined arg "missing"`,
+			},
+		},
+		{
+			name: "invalid triggers",
+			config: `on:
+  event: [push, merge]
+  schedule:
+    cron: "61 * * * *"
+    timezone: Mars/Olympus
+jobs:
+  build:
+    run-on: alpine
+    steps:
+      - run: make
+`,
+			want: []string{
+				`2:17: event must be push, tag or pull_request, got "merge"`,
+				`4:11: minute: "61" is out of range 0-59`,
+				`5:15: unknown timezone "Mars/Olympus"`,
+			},
+		},
+		{
+			name:   "no job",
+			config: "jobs: {}\n",
+			want:   []string{`1:1: no job is defined`},
+		},
+		{
+			name:   "empty",
+			config: "",
+			want:   []string{`1:1: configuration is empty`},
+		},
+		{
+			name: "syntax error",
+			config: `jobs:
+  build:
+    run-on: [alpine
+`,
+			want: []string{`2:1: line 2: did not find expected ',' or ']'`},
+		},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := filepath.Join(dir, "ci.yaml")
+			if err := ioutil.WriteFile(configFile, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			diagnostics, err := ValidateProjectConfig(configFile, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(diagnostics))
+			for _, d := range diagnostics {
+				if d.File != configFile {
+					t.Errorf("diagnostic of file %s, want %s", d.File, configFile)
+				}
+				got = append(got, fmt.Sprintf("%d:%d: %s", d.Line, d.Column, d.Message))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got diagnostics\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	bolt "go.etcd.io/bbolt"
+)
+
+const StatusRunning = "running"
+
+// ErrNotFound is returned when a run does not exist.
+var ErrNotFound = errors.New("not found")
+
+var (
+	runsBucket = []byte("runs")
+	logsBucket = []byte("logs")
+)
+
+// Run is a run of an action, with the jobs it ran.
+type Run struct {
+	Id      uint64 `json:"id"`
+	Project string `json:"project"`
+	Action  string `json:"action"`
+	// Job is the job asked to run, empty when every job of action is run.
+	Job string `json:"job,omitempty"`
+	// Trigger tells what started run, e.g. manual, schedule or a push.
+	Trigger    string   `json:"trigger"`
+	TriggerEnv []string `json:"trigger_env,omitempty"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	// Config is the rendered configuration the run used, without args given
+	// to the run.
+	Config string    `json:"config"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Jobs   []JobRun  `json:"jobs,omitempty"`
+}
+
+// JobRun is the result of a job in a run. Its log is stored apart.
+type JobRun struct {
+	Id       string            `json:"id"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	ExitCode int               `json:"exit_code"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Steps    []core.StepReport `json:"steps,omitempty"`
+	Approval *Approval         `json:"approval,omitempty"`
+	// Image is the image job ran on, as configured, and Digest the
+	// reference pinning it, e.g. alpine@sha256:..., or id of image when it
+	// was not pulled from a registry.
+	Image  string `json:"image,omitempty"`
+	Digest string `json:"digest,omitempty"`
+	// Host is the address of the docker host job ran on.
+	Host string `json:"host,omitempty"`
+	// Log is the output of job, saved with Store.SaveLog.
+	Log []byte `json:"-"`
+}
+
+// Approval is the decision taken on a job requiring approval.
+type Approval struct {
+	Approved bool `json:"approved"`
+	// By is the name of who approved or rejected job, empty when approval
+	// timed out.
+	By        string    `json:"by,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	Requested time.Time `json:"requested"`
+	Decided   time.Time `json:"decided"`
+}
+
+// Filter selects runs. Empty fields match every run.
+type Filter struct {
+	Project string
+	Action  string
+	Job     string
+	Status  string
+	// Limit is the maximum number of runs returned, 0 is unlimited.
+	Limit int
+}
+
+func (f Filter) match(r Run) bool {
+	if f.Project != "" && f.Project != r.Project {
+		return false
+	}
+	if f.Action != "" && f.Action != r.Action {
+		return false
+	}
+	if f.Status != "" && f.Status != r.Status {
+		return false
+	}
+	if f.Job != "" {
+		if r.Job == f.Job {
+			return true
+		}
+		for _, j := range r.Jobs {
+			if j.Id == f.Job || strings.HasPrefix(j.Id, f.Job+"[") {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Store keeps runs in a bbolt database. The database is only opened during an
+// operation, so that several processes may record runs.
+type Store struct {
+	Path string
+}
+
+func (s *Store) open() (*bolt.DB, error) {
+	db, err := bolt.Open(s.Path, 0644, &bolt.Options{
+		Timeout: 10 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf(`failed to open history %s: %v`, s.Path, err)
+	}
+	return db, nil
+}
+
+func (s *Store) update(f func(tx *bolt.Tx) error) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	return db.Update(f)
+}
+
+func (s *Store) view(f func(tx *bolt.Tx) error) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	return db.View(f)
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func logKey(runId uint64, jobId string) []byte {
+	return append(itob(runId), []byte(jobId)...)
+}
+
+// Create records a new run and sets its id.
+func (s *Store) Create(r *Run) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bck, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		r.Id, err = bck.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return bck.Put(itob(r.Id), data)
+	})
+}
+
+// Save overrides the record of run.
+func (s *Store) Save(r Run) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		bck, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		return bck.Put(itob(r.Id), data)
+	})
+}
+
+// SaveLog records log of job in run.
+func (s *Store) SaveLog(runId uint64, jobId string, log []byte) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bck, err := tx.CreateBucketIfNotExists(logsBucket)
+		if err != nil {
+			return err
+		}
+		return bck.Put(logKey(runId, jobId), log)
+	})
+}
+
+// Get returns run of id.
+func (s *Store) Get(id uint64) (Run, error) {
+	var r Run
+	found := false
+	err := s.view(func(tx *bolt.Tx) error {
+		bck := tx.Bucket(runsBucket)
+		if bck == nil {
+			return nil
+		}
+		data := bck.Get(itob(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &r)
+	})
+	if err == nil && !found {
+		err = fmt.Errorf(`run %d %w`, id, ErrNotFound)
+	}
+	return r, err
+}
+
+// List returns runs matching filter, most recent first.
+func (s *Store) List(f Filter) ([]Run, error) {
+	var runs []Run
+	err := s.view(func(tx *bolt.Tx) error {
+		bck := tx.Bucket(runsBucket)
+		if bck == nil {
+			return nil
+		}
+		c := bck.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var r Run
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if !f.match(r) {
+				continue
+			}
+			runs = append(runs, r)
+			if f.Limit > 0 && len(runs) >= f.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// Log returns log of job in run.
+func (s *Store) Log(runId uint64, jobId string) ([]byte, error) {
+	var log []byte
+	err := s.view(func(tx *bolt.Tx) error {
+		bck := tx.Bucket(logsBucket)
+		if bck == nil {
+			return nil
+		}
+		if v := bck.Get(logKey(runId, jobId)); v != nil {
+			log = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return log, err
+}
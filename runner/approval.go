@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+)
+
+// Approver decides whether jobs requiring approval may run.
+type Approver interface {
+	// Approve blocks until job of req is approved or rejected, or ctx is
+	// done, which happens at deadline of req at the latest.
+	Approve(ctx context.Context, req ApprovalRequest) (history.Approval, error)
+}
+
+// ApprovalRequest asks to approve a job of a run before it starts.
+type ApprovalRequest struct {
+	// RunId is the id of run in history, 0 when history is disabled.
+	RunId     uint64    `json:"run_id"`
+	Project   string    `json:"project"`
+	Action    string    `json:"action"`
+	Job       string    `json:"job"`
+	Approvers []string  `json:"approvers,omitempty"`
+	Requested time.Time `json:"requested"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+// Allows tells whether name may approve job of req.
+func (req ApprovalRequest) Allows(name string) bool {
+	c := core.ApprovalConfig{Approvers: req.Approvers}
+	return c.Allows(name)
+}
+
+type approvalResult struct {
+	id       string
+	approval history.Approval
+	err      error
+}
+
+// waitApproval asks Approver to approve job. Job is rejected when runner has
+// no approver or when nobody decides before timeout of approval.
+func (r *Runner) waitApproval(ctx context.Context, runId uint64, action string, job core.JobConfig) (history.Approval, error) {
+	timeout := core.DefaultApprovalTimeout
+	if t := strings.TrimSpace(job.Approval.Timeout); t != "" {
+		d, err := core.ParseDuration(t)
+		if err != nil {
+			return history.Approval{}, fmt.Errorf(`invalid approval timeout: %v`, err)
+		}
+		if d > 0 {
+			timeout = d
+		}
+	}
+	req := ApprovalRequest{
+		RunId:     runId,
+		Project:   r.Pwd,
+		Action:    action,
+		Job:       job.Id,
+		Approvers: job.Approval.Approvers,
+		Requested: time.Now(),
+	}
+	req.Deadline = req.Requested.Add(timeout)
+	if r.Approver == nil {
+		return history.Approval{
+			Comment:   "no approver is available",
+			Requested: req.Requested,
+			Decided:   time.Now(),
+		}, nil
+	}
+
+	log.Printf("job %s is waiting for approval until %s", job.Id, req.Deadline.Format(time.RFC3339))
+	actx, cancel := context.WithDeadline(ctx, req.Deadline)
+	defer cancel()
+	a, err := r.Approver.Approve(actx, req)
+	if err != nil && ctx.Err() == nil && actx.Err() == context.DeadlineExceeded {
+		return history.Approval{
+			Comment:   fmt.Sprintf("approval timed out after %s", timeout),
+			Requested: req.Requested,
+			Decided:   time.Now(),
+		}, nil
+	}
+	if err != nil {
+		return history.Approval{}, err
+	}
+	a.Requested = req.Requested
+	if a.Decided.IsZero() {
+		a.Decided = time.Now()
+	}
+	return a, nil
+}
+
+// rejection describes why job is rejected.
+func rejection(a history.Approval) string {
+	switch {
+	case a.By == "" && a.Comment == "":
+		return "approval is rejected"
+	case a.By == "":
+		return a.Comment
+	case a.Comment == "":
+		return fmt.Sprintf("rejected by %s", a.By)
+	}
+	return fmt.Sprintf("rejected by %s: %s", a.By, a.Comment)
+}
@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+)
+
+// recordExecutor records the order jobs are executed in.
+type recordExecutor struct {
+	mu   sync.Mutex
+	jobs []string
+}
+
+func (e *recordExecutor) ExecuteJob(ctx context.Context, l *log.Logger, r *Runner, configFile string, job core.JobConfig, envs []string, rec *history.JobRun) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.jobs = append(e.jobs, job.Id)
+	return nil
+}
+
+// delayedApprover decides after delay.
+type delayedApprover struct {
+	delay    time.Duration
+	approved bool
+}
+
+func (a delayedApprover) Approve(ctx context.Context, req ApprovalRequest) (history.Approval, error) {
+	select {
+	case <-time.After(a.delay):
+	case <-ctx.Done():
+		return history.Approval{}, ctx.Err()
+	}
+	return history.Approval{Approved: a.approved, By: "alice", Requested: req.Requested, Decided: time.Now()}, nil
+}
+
+const gatedConfig = `jobs:
+  prod:
+    run-on: alpine
+    approval:
+      required: true
+    steps:
+      - run: echo deploy
+  notify:
+    run-on: alpine
+    needs: [prod]
+    steps:
+      - run: echo notify
+`
+
+func runGated(t *testing.T, approved bool) ([]string, error) {
+	dir, err := ioutil.TempDir("", "vulcan-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "deploy.yaml")
+	if err = ioutil.WriteFile(configFile, []byte(gatedConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := &recordExecutor{}
+	r := &Runner{
+		Pwd:      dir,
+		Parallel: 2,
+		Executor: e,
+		Approver: delayedApprover{delay: 200 * time.Millisecond, approved: approved},
+	}
+	err = r.RunConfigFile(context.Background(), configFile, "", nil)
+	return e.jobs, err
+}
+
+func TestNeedsWaitForApproval(t *testing.T) {
+	jobs, err := runGated(t, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0] != "prod" || jobs[1] != "notify" {
+		t.Fatalf("jobs ran in order %v, want [prod notify]", jobs)
+	}
+}
+
+func TestNeedsOfRejectedJobAreSkipped(t *testing.T) {
+	jobs, err := runGated(t, false)
+	if err == nil {
+		t.Fatalf("rejected run must fail")
+	}
+	if errors.Is(err, ErrCancelled) {
+		t.Fatalf("rejected run must not be cancelled: %v", err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("jobs %v ran although prod was rejected", jobs)
+	}
+}
@@ -0,0 +1,192 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/scm"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Locker serializes runs and jobs of the same concurrency group.
+type Locker interface {
+	// Lock blocks until group is free, then holds it until unlock is called.
+	// The returned context is done once ctx is, or once holder is cancelled
+	// in progress by another one. With cancelInProgress, the holder of group
+	// is cancelled instead of being waited for.
+	Lock(ctx context.Context, l *log.Logger, group string, cancelInProgress bool) (context.Context, func(), error)
+}
+
+// lockPoll is the time between two attempts to lock a group, or to look for
+// cancellation of a holder.
+const lockPoll = time.Second
+
+// FileLocker locks groups with files in Dir, so that runs of several
+// processes on a host are serialized.
+//
+// A holder writes a token in <group>.holder. It is cancelled in progress once
+// its token is written in <group>.cancel.
+type FileLocker struct {
+	Dir string
+}
+
+// FileLocks returns the locker of locks directory in VULCAN_HOME, or in
+// temporary directory when there is no VULCAN_HOME.
+func FileLocks() *FileLocker {
+	dir, err := HomeDir("", "locks")
+	if err != nil {
+		dir = filepath.Join(os.TempDir(), "vulcan-locks")
+	}
+	return &FileLocker{Dir: dir}
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// lockName returns base name of files of group, readable but unique.
+func lockName(group string) string {
+	name := unsafeChars.ReplaceAllString(group, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return fmt.Sprintf("%s-%x", name, sha1.Sum([]byte(group)))[:len(name)+9]
+}
+
+func (f *FileLocker) Lock(ctx context.Context, l *log.Logger, group string, cancelInProgress bool) (context.Context, func(), error) {
+	err := os.MkdirAll(f.Dir, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+	base := filepath.Join(f.Dir, lockName(group))
+	token := fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+	logged := false
+	var db *bolt.DB
+	for {
+		if cancelInProgress {
+			//a holder which changed while waiting is cancelled as well
+			if holder, err := ioutil.ReadFile(base + ".holder"); err == nil && len(holder) > 0 {
+				_ = ioutil.WriteFile(base+".cancel", holder, 0644)
+			}
+		}
+		timeout := lockPoll
+		if !logged {
+			timeout = 100 * time.Millisecond
+		}
+		db, err = bolt.Open(base+".lock", 0644, &bolt.Options{Timeout: timeout})
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bolt.ErrTimeout) {
+			return nil, nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if !logged {
+			logged = true
+			if cancelInProgress {
+				l.Printf("cancelling concurrency group %s in progress", group)
+			} else {
+				l.Printf("waiting for concurrency group %s", group)
+			}
+		}
+	}
+
+	err = ioutil.WriteFile(base+".holder", []byte(token), 0644)
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, err
+	}
+	lctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockPoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if data, err := ioutil.ReadFile(base + ".cancel"); err == nil && string(data) == token {
+				_ = os.Remove(base + ".cancel")
+				l.Printf("cancelled by a newer run of concurrency group %s", group)
+				cancel()
+				return
+			}
+		}
+	}()
+	unlock := func() {
+		close(done)
+		cancel()
+		if data, err := ioutil.ReadFile(base + ".holder"); err == nil && string(data) == token {
+			_ = os.Remove(base + ".holder")
+		}
+		_ = db.Close()
+	}
+	return lctx, unlock, nil
+}
+
+// lock holds concurrency group c rendered against data. Nothing is locked
+// when c is nil.
+func (r *Runner) lock(ctx context.Context, l *log.Logger, c *core.ConcurrencyConfig, data map[string]interface{}) (context.Context, func(), error) {
+	if c == nil || strings.TrimSpace(c.Group) == "" {
+		return ctx, func() {}, nil
+	}
+	group, err := core.RenderTemplate(strings.TrimSpace(c.Group), data)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`invalid concurrency group: %v`, err)
+	}
+	locker := r.Locker
+	if locker == nil {
+		locker = FileLocks()
+	}
+	return locker.Lock(ctx, l, group, c.CancelInProgress)
+}
+
+// groupData returns values concurrency groups are rendered against: args,
+// matrix and core.ConcurrencyVars, taken from variables describing trigger
+// among envs. Branch of a run which is not triggered is the one checked out in
+// project directory.
+func (r *Runner) groupData(action, jobId string, args, matrix map[string]string, envs []string) map[string]interface{} {
+	data := make(map[string]interface{})
+	for k, v := range args {
+		data[k] = v
+	}
+	if matrix == nil {
+		matrix = make(map[string]string)
+	}
+	data["matrix"] = matrix
+	vars := make(map[string]struct{})
+	for _, k := range core.ConcurrencyVars {
+		vars[k] = struct{}{}
+		data[k] = ""
+	}
+	triggered := false
+	for _, kv := range envs {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv, "VULCAN_") {
+			continue
+		}
+		k := strings.ToLower(strings.TrimPrefix(kv[:i], "VULCAN_"))
+		if _, ok := vars[k]; ok {
+			data[k] = kv[i+1:]
+			triggered = triggered || k == "event"
+		}
+	}
+	data["action"] = action
+	data["job"] = jobId
+	if !triggered {
+		data["branch"] = scm.Repository{Path: r.Pwd}.DefaultBranch()
+	}
+	return data
+}
@@ -0,0 +1,200 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+)
+
+func TestLockName(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	tests := []struct {
+		group  string
+		prefix string
+	}{
+		{"deploy", "deploy-"},
+		{"ci/main branch", "ci_main_branch-"},
+		{"ci-main.v1_2", "ci-main.v1_2-"},
+		{long, strings.Repeat("a", 64) + "-"},
+	}
+	for _, tt := range tests {
+		name := lockName(tt.group)
+		if !strings.HasPrefix(name, tt.prefix) || len(name) != len(tt.prefix)+8 {
+			t.Errorf("lockName(%q) = %q, want %s followed by 8 characters of hash", tt.group, name, tt.prefix)
+		}
+		if name != lockName(tt.group) {
+			t.Errorf("lockName(%q) is not stable", tt.group)
+		}
+	}
+	if lockName("ci/main") == lockName("ci main") {
+		t.Errorf("groups with the same readable name must have their own files")
+	}
+}
+
+func TestFileLockerSerializesHolders(t *testing.T) {
+	f := &FileLocker{Dir: t.TempDir()}
+	base := filepath.Join(f.Dir, lockName("deploy"))
+	l := log.New(ioutil.Discard, "", 0)
+
+	_, unlock, err := f.Lock(context.Background(), l, "deploy", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder, err := ioutil.ReadFile(base + ".holder")
+	if err != nil || len(holder) == 0 {
+		t.Fatalf("token of holder is not written: %v", err)
+	}
+
+	locked := make(chan func())
+	go func() {
+		_, unlock, err := f.Lock(context.Background(), l, "deploy", false)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- unlock
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("group is locked twice")
+	case <-time.After(300 * time.Millisecond):
+	}
+	//other groups are not waiting
+	_, unlockOther, err := f.Lock(context.Background(), l, "release", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockOther()
+
+	unlock()
+	select {
+	case unlock = <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("group is not locked once it is free")
+	}
+	next, err := ioutil.ReadFile(base + ".holder")
+	if err != nil || string(next) == string(holder) {
+		t.Fatalf("token of next holder is not written: %q", next)
+	}
+	unlock()
+	if _, err := os.Stat(base + ".holder"); !os.IsNotExist(err) {
+		t.Fatalf("token of holder is left once unlocked: %v", err)
+	}
+}
+
+func TestFileLockerCancelsHolderInProgress(t *testing.T) {
+	f := &FileLocker{Dir: t.TempDir()}
+	base := filepath.Join(f.Dir, lockName("deploy"))
+	var out bytes.Buffer
+	holderCtx, unlock, err := f.Lock(context.Background(), log.New(&out, "", 0), "deploy", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the holder stops once it is cancelled
+	go func() {
+		<-holderCtx.Done()
+		unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	lctx, unlockNewer, err := f.Lock(ctx, log.New(ioutil.Discard, "", 0), "deploy", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlockNewer()
+	if holderCtx.Err() == nil {
+		t.Fatalf("holder is not cancelled")
+	}
+	if lctx.Err() != nil {
+		t.Fatalf("newer holder is cancelled as well")
+	}
+	if !strings.Contains(out.String(), "cancelled by a newer run of concurrency group deploy") {
+		t.Errorf("cancellation is not logged by holder:\n%s", out.String())
+	}
+	if holder, err := ioutil.ReadFile(base + ".holder"); err != nil || len(holder) == 0 {
+		t.Errorf("token of newer holder is not written: %v", err)
+	}
+}
+
+func TestFileLockerWaitingIsCancelled(t *testing.T) {
+	f := &FileLocker{Dir: t.TempDir()}
+	_, unlock, err := f.Lock(context.Background(), log.New(ioutil.Discard, "", 0), "deploy", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, _, err = f.Lock(ctx, log.New(&out, "", 0), "deploy", false)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !strings.Contains(out.String(), "waiting for concurrency group deploy") {
+		t.Errorf("waiting is not logged:\n%s", out.String())
+	}
+}
+
+// overlapExecutor records the most jobs running at the same time.
+type overlapExecutor struct {
+	mu      sync.Mutex
+	running int
+	most    int
+}
+
+func (e *overlapExecutor) ExecuteJob(ctx context.Context, l *log.Logger, r *Runner, configFile string, job core.JobConfig, envs []string, rec *history.JobRun) error {
+	e.mu.Lock()
+	e.running++
+	if e.running > e.most {
+		e.most = e.running
+	}
+	e.mu.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	e.mu.Lock()
+	e.running--
+	e.mu.Unlock()
+	return nil
+}
+
+func TestRunsOfGroupAreSerialized(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "deploy.yaml")
+	config := `concurrency:
+  group: deploy-{{.branch}}
+jobs:
+  deploy:
+    run-on: alpine
+    steps:
+      - run: deploy
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := &overlapExecutor{}
+	r := &Runner{Pwd: dir, Parallel: 1, Executor: e, Locker: &FileLocker{Dir: t.TempDir()}}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.RunConfigFile(context.Background(), configFile, "", []string{"VULCAN_EVENT=push", "VULCAN_BRANCH=main"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if e.most != 1 {
+		t.Fatalf("%d runs of the same group ran at the same time", e.most)
+	}
+}
@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/locngoxuan/vulcan/core"
+)
+
+// ConnectDocker connects r to docker hosts of configuration file configDocker,
+// the local one when it is empty, whose registries images are pulled from.
+// With several hosts, jobs are distributed across them. The returned function
+// closes connections.
+func (r *Runner) ConnectDocker(ctx context.Context, configDocker string) (func(), error) {
+	var config core.DockerConfig
+	var err error
+	if configDocker = strings.TrimSpace(configDocker); configDocker != "" {
+		config, err = core.ReadDockerConfig(configDocker)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read docker configuration: %v", err)
+		}
+	}
+	dockerCli, err := core.ConnectDockerHost(ctx, config.DockerHosts())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect docker host: %v", err)
+	}
+	dockerCli.DockerConfig = config
+	r.DockerCli = dockerCli
+	if len(config.Hosts) < 2 {
+		return dockerCli.Close, nil
+	}
+	//jobs are distributed across hosts
+	r.Hosts, err = NewDockerHosts(ctx, config)
+	if err != nil {
+		dockerCli.Close()
+		return nil, fmt.Errorf("failed to connect docker hosts: %v", err)
+	}
+	return func() {
+		r.Hosts.Close()
+		dockerCli.Close()
+	}, nil
+}
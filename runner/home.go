@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/locngoxuan/vulcan/history"
+)
+
+// VulcanHome returns VULCAN_HOME, or the parent of bin directory containing
+// vlocal when it is not set.
+func VulcanHome() (string, error) {
+	home := strings.TrimSpace(os.Getenv("VULCAN_HOME"))
+	if home != "" {
+		return home, nil
+	}
+	p, err := exec.LookPath("vlocal")
+	if err != nil {
+		return "", err
+	}
+	//return bin
+	return filepath.Dir(filepath.Dir(p)), nil
+}
+
+// HomeDir returns dir, or directory name of VULCAN_HOME when dir is empty.
+func HomeDir(dir, name string) (string, error) {
+	if dir = strings.TrimSpace(dir); dir != "" {
+		return dir, nil
+	}
+	home, err := VulcanHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, name), nil
+}
+
+// TemplateDir returns dir, or templates directory of VULCAN_HOME when dir is
+// empty. Empty is returned if there is no such directory.
+func TemplateDir(dir string) string {
+	if dir = strings.TrimSpace(dir); dir != "" {
+		return dir
+	}
+	home, err := VulcanHome()
+	if err != nil {
+		return ""
+	}
+	dir = filepath.Join(home, "templates")
+	if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+		return ""
+	}
+	return dir
+}
+
+// HistoryStore returns the store of runs in VULCAN_HOME, nil when there is no
+// VULCAN_HOME.
+func HistoryStore() *history.Store {
+	home, err := VulcanHome()
+	if err != nil {
+		return nil
+	}
+	if err = os.MkdirAll(home, 0755); err != nil {
+		return nil
+	}
+	return &history.Store{Path: filepath.Join(home, "history.db")}
+}
@@ -0,0 +1,212 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+)
+
+// hostTimeout bounds the time a docker host is given to tell its load.
+const hostTimeout = 10 * time.Second
+
+// DockerHosts places jobs on the least loaded of several docker hosts, keeping
+// a client per host.
+type DockerHosts struct {
+	mu    sync.Mutex
+	hosts []*dockerHost
+}
+
+type dockerHost struct {
+	cli core.DockerClient
+	// active is the number of jobs placed on host and still running, some of
+	// them may not have started their container yet.
+	active      int
+	unreachable bool
+}
+
+// NewDockerHosts returns clients of hosts of config. It fails unless one of
+// them at least answers.
+func NewDockerHosts(ctx context.Context, config core.DockerConfig) (*DockerHosts, error) {
+	d := &DockerHosts{}
+	var err error
+	reachable := false
+	for _, host := range config.DockerHosts() {
+		cli, cerr := core.NewDockerClient(host)
+		if cerr != nil {
+			d.Close()
+			return nil, cerr
+		}
+		cli.DockerConfig = config
+		d.hosts = append(d.hosts, &dockerHost{cli: cli})
+		if reachable {
+			continue
+		}
+		ictx, cancel := context.WithTimeout(ctx, hostTimeout)
+		_, err = cli.Client.Info(ictx)
+		cancel()
+		if err != nil {
+			err = fmt.Errorf("%s: %v", host.Address, err)
+		}
+		reachable = err == nil
+	}
+	if !reachable {
+		d.Close()
+		return nil, fmt.Errorf("connect docker host error: %v", err)
+	}
+	return d, nil
+}
+
+func (d *DockerHosts) Close() {
+	for _, h := range d.hosts {
+		h.cli.Close()
+	}
+}
+
+// place returns the reachable host, out of tried ones, which runs the fewest
+// containers for its capacity, then release to call once job is done. Load
+// of a host is the number of its running containers, or of jobs placed on it
+// when it is greater. Capacity of a host is the number of its CPUs unless it
+// is configured. place waits while every host is full.
+func (d *DockerHosts) place(ctx context.Context, l *log.Logger, tried map[*dockerHost]struct{}) (*dockerHost, func(), error) {
+	logged := false
+	for {
+		probes := d.probe(ctx, l, tried)
+
+		//hosts are only locked to pick one, a slow host must not hold others
+		d.mu.Lock()
+		var best *dockerHost
+		bestLoad := 0.0
+		reachable := 0
+		for _, p := range probes {
+			if p.err != nil {
+				continue
+			}
+			reachable++
+			running := p.running
+			if p.host.active > running {
+				running = p.host.active
+			}
+			if running >= p.capacity {
+				continue
+			}
+			load := float64(running) / float64(p.capacity)
+			if best == nil || load < bestLoad {
+				best, bestLoad = p.host, load
+			}
+		}
+		if best != nil {
+			best.active++
+			d.mu.Unlock()
+			h := best
+			return h, func() {
+				d.mu.Lock()
+				h.active--
+				d.mu.Unlock()
+			}, nil
+		}
+		d.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if reachable == 0 {
+			return nil, nil, fmt.Errorf("no docker host is reachable")
+		}
+		if !logged {
+			logged = true
+			l.Printf("waiting for a docker host, all of them are full")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+}
+
+// hostProbe is the load a host told, or the error it failed with.
+type hostProbe struct {
+	host     *dockerHost
+	running  int
+	capacity int
+	err      error
+}
+
+// probe asks hosts but tried ones for their load at the same time.
+func (d *DockerHosts) probe(ctx context.Context, l *log.Logger, tried map[*dockerHost]struct{}) []hostProbe {
+	probes := make([]hostProbe, 0, len(d.hosts))
+	for _, h := range d.hosts {
+		if _, ok := tried[h]; !ok {
+			probes = append(probes, hostProbe{host: h})
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range probes {
+		wg.Add(1)
+		go func(p *hostProbe) {
+			defer wg.Done()
+			ictx, cancel := context.WithTimeout(ctx, hostTimeout)
+			defer cancel()
+			info, err := p.host.cli.Client.Info(ictx)
+			if err != nil {
+				p.err = err
+				return
+			}
+			p.running = info.ContainersRunning
+			p.capacity = p.host.cli.Host.Capacity
+			if p.capacity <= 0 {
+				p.capacity = info.NCPU
+			}
+			if p.capacity <= 0 {
+				p.capacity = 1
+			}
+		}(&probes[i])
+	}
+	wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, p := range probes {
+		h := p.host
+		if p.err != nil && !h.unreachable && ctx.Err() == nil {
+			l.Printf("docker host %s is unreachable: %v", h.cli.Host.Address, p.err)
+		}
+		h.unreachable = p.err != nil
+	}
+	return probes
+}
+
+// reachable tells whether h still answers.
+func (d *DockerHosts) reachable(ctx context.Context, h *dockerHost) bool {
+	ictx, cancel := context.WithTimeout(ctx, hostTimeout)
+	defer cancel()
+	_, err := h.cli.Client.Info(ictx)
+	return err == nil
+}
+
+// runJobOnHosts runs job on the least loaded of docker hosts of runner. A job
+// whose host becomes unreachable is run again on another host.
+func (r *Runner) runJobOnHosts(ctx context.Context, l *log.Logger, configFile string, job core.JobConfig, envs []string, rec *history.JobRun) error {
+	tried := make(map[*dockerHost]struct{})
+	for {
+		h, release, err := r.Hosts.place(ctx, l, tried)
+		if err != nil {
+			return err
+		}
+		hr := *r
+		hr.DockerCli = h.cli
+		err = hr.runJob(ctx, l, configFile, job, envs, rec)
+		release()
+		if err == nil || ctx.Err() != nil || r.Hosts.reachable(ctx, h) {
+			return err
+		}
+		l.Printf("docker host %s became unreachable, running job on another host: %v", h.cli.Host.Address, err)
+		tried[h] = struct{}{}
+		*rec = history.JobRun{Id: rec.Id, Start: rec.Start}
+	}
+}
@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+)
+
+// fakeHost serves info of a docker host running containers out of ncpu, after
+// delay.
+func fakeHost(t *testing.T, ncpu, running int, delay time.Duration) (*httptest.Server, *dockerHost) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"NCPU":%d,"ContainersRunning":%d}`, ncpu, running)
+	}))
+	cli, err := core.NewDockerClient(core.DockerHost{Address: "tcp://" + strings.TrimPrefix(srv.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, &dockerHost{cli: cli}
+}
+
+func TestPlaceOnLeastLoadedHost(t *testing.T) {
+	busySrv, busy := fakeHost(t, 4, 3, 0)
+	defer busySrv.Close()
+	idleSrv, idle := fakeHost(t, 4, 0, 0)
+	defer idleSrv.Close()
+	downSrv, down := fakeHost(t, 4, 0, 0)
+	downSrv.Close()
+	d := &DockerHosts{hosts: []*dockerHost{down, busy, idle}}
+	defer d.Close()
+	l := log.New(ioutil.Discard, "", 0)
+
+	//jobs placed on idle count as its load, so that busy gets one at last
+	want := []*dockerHost{idle, idle, idle, busy}
+	for i, w := range want {
+		h, _, err := d.place(context.Background(), l, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h != w {
+			t.Fatalf("job %d is placed on %s, want %s", i, h.cli.Host.Address, w.cli.Host.Address)
+		}
+	}
+	if !down.unreachable {
+		t.Fatalf("host which does not answer must be unreachable")
+	}
+	h, _, err := d.place(context.Background(), l, map[*dockerHost]struct{}{idle: {}, busy: {}})
+	if err == nil {
+		t.Fatalf("job is placed on %s although no host is reachable", h.cli.Host.Address)
+	}
+}
+
+func TestPlaceProbesHostsConcurrently(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	hosts := make([]*dockerHost, 0)
+	for i := 0; i < 3; i++ {
+		srv, h := fakeHost(t, 4, 0, delay)
+		defer srv.Close()
+		hosts = append(hosts, h)
+	}
+	d := &DockerHosts{hosts: hosts}
+	defer d.Close()
+	l := log.New(ioutil.Discard, "", 0)
+
+	//probing 3 hosts for 4 jobs one host and one job after another would
+	//take 12 delays
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := d.place(context.Background(), l, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 4*delay {
+		t.Fatalf("placing jobs took %s, hosts are not probed concurrently", elapsed)
+	}
+}
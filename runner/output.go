@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// Stderr is shared by every job so that lines written by concurrent jobs
+// never get mixed up.
+var Stderr = &syncWriter{out: os.Stderr}
+
+type syncWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(p)
+}
+
+// lineWriter buffers output until a line is completed, then writes the whole
+// line with its prefix in a single call.
+type lineWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    bytes.Buffer
+}
+
+func newLineWriter(out io.Writer, prefix string) *lineWriter {
+	return &lineWriter{
+		out:    out,
+		prefix: prefix,
+	}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf.Next(i + 1)
+		_, err := w.out.Write(append([]byte(w.prefix), line...))
+		if err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out the remaining of an incomplete line.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() == 0 {
+		return
+	}
+	line := append([]byte(w.prefix), w.buf.Bytes()...)
+	_, _ = w.out.Write(append(line, '\n'))
+	w.buf.Reset()
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writes records every call to Write.
+type writes struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (w *writes) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.calls = append(w.calls, string(p))
+	return len(p), nil
+}
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string
+	}{
+		{"whole lines", []string{"a\n", "b\n"}, []string{"[job] a\n", "[job] b\n"}},
+		{"split lines", []string{"a", "b", "c\nd", "e\n"}, []string{"[job] abc\n", "[job] de\n"}},
+		{"several lines at once", []string{"a\n\nb\n"}, []string{"[job] a\n", "[job] \n", "[job] b\n"}},
+		{"incomplete line is flushed", []string{"a\nb"}, []string{"[job] a\n", "[job] b\n"}},
+		{"nothing to flush", nil, nil},
+	}
+	for _, tt := range tests {
+		out := &writes{}
+		w := newLineWriter(out, "[job] ")
+		for _, s := range tt.writes {
+			n, err := w.Write([]byte(s))
+			if err != nil || n != len(s) {
+				t.Fatalf("%s: Write(%q) = %d, %v", tt.name, s, n, err)
+			}
+		}
+		w.Flush()
+		if fmt.Sprint(out.calls) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got writes %q, want %q", tt.name, out.calls, tt.want)
+		}
+	}
+}
+
+func TestLineWritersOfJobsDoNotMixLines(t *testing.T) {
+	out := &writes{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(job string) {
+			defer wg.Done()
+			w := newLineWriter(out, "["+job+"] ")
+			defer w.Flush()
+			for j := 0; j < 100; j++ {
+				for _, part := range []string{job, " line ", fmt.Sprint(j), "\n"} {
+					_, _ = w.Write([]byte(part))
+				}
+			}
+		}(fmt.Sprintf("job%d", i))
+	}
+	wg.Wait()
+	if len(out.calls) != 400 {
+		t.Fatalf("got %d writes, want 400", len(out.calls))
+	}
+	for _, line := range out.calls {
+		var job, name string
+		var n int
+		if _, err := fmt.Sscanf(line, "[%s %s line %d\n", &job, &name, &n); err != nil || strings.TrimSuffix(job, "]") != name {
+			t.Fatalf("line %q is mixed up", line)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+)
+
+var workDir = "/workdir"
+
+// RunJob runs job of configuration file in a container of docker host, or of
+// one of Hosts, whatever the executor of runner is. Build agents use it to run
+// jobs they are assigned.
+func (r *Runner) RunJob(ctx context.Context, l *log.Logger, configFile string, job core.JobConfig, envs []string, rec *history.JobRun) error {
+	if r.Hosts != nil {
+		return r.runJobOnHosts(ctx, l, configFile, job, envs, rec)
+	}
+	return r.runJob(ctx, l, configFile, job, envs, rec)
+}
+
+// runJob runs job in a container. Exit code, results of steps and, when
+// history is enabled, output of job are recorded in rec.
+func (r *Runner) runJob(ctx context.Context, l *log.Logger, configFile string, jobConfig core.JobConfig, envs []string, rec *history.JobRun) error {
+	l.Printf("Job: %s", jobConfig.Id)
+	rec.Host = r.DockerCli.Host.Address
+	if r.Hosts != nil {
+		l.Printf("Host: %s", rec.Host)
+	}
+	timeout, err := core.ParseDuration(jobConfig.Timeout)
+	if err != nil {
+		return fmt.Errorf(`invalid timeout: %v`, err)
+	}
+	mounts := make([]mount.Mount, 0)
+	baseDir := filepath.Join(r.Pwd, jobConfig.BaseDir)
+	vulcanConfig := filepath.Join(r.Pwd, ".vulcan")
+	sources := make(map[string]struct{})
+	targets := make(map[string]struct{})
+
+	//mount vucal configuration folder
+	if _, ok := sources[vulcanConfig]; !ok {
+		sources[vulcanConfig] = struct{}{}
+		target := filepath.Join(workDir, ".vulcan")
+		targets[target] = struct{}{}
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: vulcanConfig,
+			Target: target,
+		})
+	}
+
+	//mount artifact first
+	if jobConfig.Artifacts != nil {
+		for _, artifact := range jobConfig.Artifacts {
+			parts := strings.Split(artifact, ":")
+			host := strings.TrimSpace(parts[0])
+			target := strings.TrimSpace(parts[1])
+			if strings.HasPrefix(host, "~") {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return err
+				}
+				host = filepath.Join(home, host[1:])
+			}
+
+			if strings.HasPrefix(target, "/") {
+
+			} else if strings.HasPrefix(target, "~") {
+				target = filepath.Join("/root", target)
+			} else {
+				target = filepath.Join(workDir, target)
+			}
+
+			h, err := filepath.Abs(host)
+			if err != nil {
+				return err
+			}
+			t, err := filepath.Abs(target)
+			if err != nil {
+				return err
+			}
+
+			_, err = os.Stat(h)
+			if err != nil {
+				if os.IsNotExist(err) {
+					err = os.MkdirAll(h, 0755)
+					if err != nil {
+						return err
+					}
+				} else {
+					return err
+				}
+			}
+
+			if _, ok := sources[h]; !ok {
+				if _, tok := targets[t]; !tok {
+					mounts = append(mounts, mount.Mount{
+						Type:   mount.TypeBind,
+						Source: h,
+						Target: t,
+					})
+				}
+			}
+
+		}
+	}
+
+	err = filepath.Walk(baseDir, func(path string, info fs.FileInfo, err error) error {
+		if strings.HasPrefix(path, vulcanConfig) {
+			return nil
+		}
+		if baseDir == path {
+			return nil
+		}
+		if !info.IsDir() {
+			if filepath.Dir(path) != baseDir {
+				return nil
+			}
+		}
+		for p := range sources {
+			if strings.HasPrefix(path, p) {
+				return nil
+			}
+		}
+
+		f := strings.TrimPrefix(path, baseDir)
+		target := filepath.Join(workDir, f)
+
+		for p := range targets {
+			if strings.HasPrefix(target, p) {
+				return nil
+			}
+		}
+		sources[path] = struct{}{}
+		targets[target] = struct{}{}
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: path,
+			Target: target,
+		})
+		return nil
+	})
+
+	dockerCommandArg := make([]string, 0)
+	mounts = append(mounts, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: r.ToolChains,
+		Target: core.ToolChainInsideContainer,
+	})
+
+	mounts = append(mounts, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: r.Plugins,
+		Target: core.PluginInsideContainer,
+	})
+
+	configTarget := filepath.Join(core.ConfigInsideContainer, filepath.Base(configFile))
+	mounts = append(mounts, mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   configFile,
+		Target:   configTarget,
+		ReadOnly: true,
+	})
+
+	//vexec reports results of steps into a directory shared with host
+	reportDir, err := ioutil.TempDir("", "vulcan-report-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(reportDir)
+	//container may run as any user
+	err = os.Chmod(reportDir, 0777)
+	if err != nil {
+		return err
+	}
+	mounts = append(mounts, mount.Mount{
+		Type:   mount.TypeBind,
+		Source: reportDir,
+		Target: core.ReportInsideContainer,
+	})
+
+	vexecFile := filepath.Join(core.ToolChainInsideContainer, "vexec")
+	dockerCommandArg = append(dockerCommandArg, vexecFile,
+		"--config", configTarget,
+		"--job-id", jobConfig.Id,
+		"--report", filepath.Join(core.ReportInsideContainer, "report.json"))
+
+	containerConfig := &container.Config{
+		Image:        jobConfig.RunOn,
+		Cmd:          dockerCommandArg,
+		WorkingDir:   workDir,
+		Tty:          r.Verbose,
+		AttachStdout: r.Verbose,
+		Env:          envs,
+	}
+	hostConfig := &container.HostConfig{
+		Mounts: mounts,
+	}
+	if jobConfig.Hosts != nil && len(jobConfig.Hosts) > 0 {
+		hostConfig.ExtraHosts = jobConfig.Hosts
+	}
+
+	//services are reachable from job container by their names
+	networkName, cleanupServices, err := r.startServices(ctx, l, jobConfig)
+	defer cleanupServices()
+	if err != nil {
+		return err
+	}
+	if networkName != "" {
+		hostConfig.NetworkMode = container.NetworkMode(networkName)
+	}
+
+	err = r.pullImage(ctx, l, jobConfig.RunOn, jobConfig.Pull)
+	if err != nil {
+		return err
+	}
+	//container is created from the very image recorded
+	imageId, digest, err := r.DockerCli.ImageDigest(ctx, jobConfig.RunOn)
+	if err != nil {
+		return fmt.Errorf(`failed to inspect image %s: %v`, jobConfig.RunOn, err)
+	}
+	if digest == "" {
+		digest = imageId
+	}
+	rec.Image = jobConfig.RunOn
+	rec.Digest = digest
+	l.Printf("Image: %s", digest)
+	containerConfig.Image = imageId
+	cli := r.DockerCli.Client
+	cont, err := cli.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer core.RemoveAfterDone(cli, cont.ID)
+
+	err = cli.ContainerStart(ctx, cont.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return err
+	}
+	defer r.collectJob(cont.ID, filepath.Join(reportDir, "report.json"), rec)
+
+	//container is stopped once job runs out of time, then removed as usual
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if r.Verbose {
+		out, err := cli.ContainerLogs(waitCtx, cont.ID, types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Timestamps: false,
+			Follow:     true,
+			Tail:       "20",
+		})
+		if err != nil {
+			return err
+		}
+		core.StreamDockerLog(out, func(s string) {
+			l.Println(s)
+		})
+		statusCh, errCh := cli.ContainerWait(waitCtx, cont.ID, container.WaitConditionNotRunning)
+		select {
+		case err := <-errCh:
+			if err != nil {
+				r.stopContainer(cont.ID)
+				if ctx.Err() == nil && waitCtx.Err() == context.DeadlineExceeded {
+					return fmt.Errorf(`job timed out after %s`, timeout)
+				}
+				return err
+			}
+		case c := <-statusCh:
+			rec.ExitCode = int(c.StatusCode)
+			if c.StatusCode != 0 || c.Error != nil {
+				return fmt.Errorf(`exit code %v`, c.StatusCode)
+			}
+		}
+	} else {
+		if r.Output != nil {
+			tailed := make(chan struct{})
+			go func() {
+				defer close(tailed)
+				r.tailJob(waitCtx, cont.ID, jobConfig.Id)
+			}()
+			defer func() { <-tailed }()
+		}
+		statusCh, errCh := cli.ContainerWait(waitCtx, cont.ID, container.WaitConditionNotRunning)
+		select {
+		case err := <-errCh:
+			if err != nil {
+				r.stopContainer(cont.ID)
+				if ctx.Err() == nil && waitCtx.Err() == context.DeadlineExceeded {
+					return fmt.Errorf(`job timed out after %s`, timeout)
+				}
+				return err
+			}
+		case status := <-statusCh:
+			rec.ExitCode = int(status.StatusCode)
+			if status.StatusCode != 0 || status.Error != nil {
+				var buf bytes.Buffer
+				defer buf.Reset()
+				out, err := cli.ContainerLogs(context.Background(), cont.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+				if err != nil {
+					return err
+				}
+				_, err = stdcopy.StdCopy(&buf, &buf, out)
+				if err != nil {
+					return err
+				}
+				if msg := strings.TrimSpace(buf.String()); msg != "" {
+					return fmt.Errorf(`exit code %v: %s`, status.StatusCode, msg)
+				}
+				return fmt.Errorf(`exit code %v`, status.StatusCode)
+			}
+		}
+	}
+	return nil
+}
+
+// pullImage pulls image according to pull policy, with credentials of the
+// registry image belongs to.
+func (r *Runner) pullImage(ctx context.Context, l *log.Logger, image, policy string) error {
+	exist, _, err := r.DockerCli.ImageExist(ctx, image)
+	if err != nil {
+		return fmt.Errorf(`failed to look up image %s: %v`, image, err)
+	}
+	pull, err := mustPull(image, policy, exist)
+	if err != nil || !pull {
+		return err
+	}
+	l.Printf("Pull: %s", image)
+	out, err := r.DockerCli.PullImage(ctx, r.DockerCli.Registry(image), image)
+	if err != nil {
+		return fmt.Errorf(`failed to pull image %s: %v`, image, err)
+	}
+	defer out.Close()
+	msg, err := core.DisplayDockerLog(out)
+	if err != nil {
+		return fmt.Errorf(`failed to pull image %s: %v`, image, err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(msg), "\n") {
+		if line != "" {
+			l.Println(line)
+		}
+	}
+	return nil
+}
+
+// mustPull tells whether image is pulled according to pull policy, once it is
+// known whether docker host has it. An image pinned by digest never changes,
+// so it is not pulled again once docker host has it.
+func mustPull(image, policy string, exist bool) (bool, error) {
+	if policy = strings.TrimSpace(policy); policy == "" {
+		policy = core.DefaultPull
+	}
+	switch policy {
+	case core.PullAlways, core.PullIfNotPresent, core.PullNever:
+	default:
+		return false, fmt.Errorf(`invalid pull policy %q`, policy)
+	}
+	if exist && (policy != core.PullAlways || strings.Contains(image, "@")) {
+		return false, nil
+	}
+	if policy == core.PullNever {
+		return false, fmt.Errorf(`image %s is not present and pull policy is %s`, image, core.PullNever)
+	}
+	return true, nil
+}
+
+// stopContainer stops container within grace period of runner, or kills it
+// at once when Kill is done.
+func (r *Runner) stopContainer(id string) {
+	grace := r.GracePeriod
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+	ctx := r.Kill
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_ = r.DockerCli.Client.ContainerStop(ctx, id, &grace)
+}
+
+// tailJob writes output of container to Output of runner while it runs, so
+// that a job can be followed without printing its detail.
+func (r *Runner) tailJob(ctx context.Context, id, jobId string) {
+	out, err := r.DockerCli.Client.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, out)
+		_ = pw.CloseWithError(err)
+	}()
+	w := newLineWriter(r.Output, fmt.Sprintf("[%s] ", jobId))
+	defer w.Flush()
+	core.StreamDockerLog(pr, func(s string) {
+		_, _ = fmt.Fprintln(w, s)
+	})
+}
+
+// collectJob records results of steps reported by vexec and, when history is
+// enabled, the whole output of container.
+func (r *Runner) collectJob(id, reportFile string, rec *history.JobRun) {
+	if report, err := core.ReadJobReport(reportFile); err == nil {
+		rec.Steps = report.Steps
+	}
+	if r.History == nil {
+		return
+	}
+	out, err := r.DockerCli.Client.ContainerLogs(context.Background(), id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	var buf bytes.Buffer
+	if r.Verbose {
+		//output of a tty is not multiplexed
+		_, err = io.Copy(&buf, out)
+	} else {
+		_, err = stdcopy.StdCopy(&buf, &buf, out)
+	}
+	if err == nil {
+		rec.Log = buf.Bytes()
+	}
+}
@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/locngoxuan/vulcan/core"
+)
+
+func TestMustPull(t *testing.T) {
+	const pinned = "alpine@sha256:074d3636ebda6dd446d0d00304c4454f468237fdacf08fb0eeac90bdbfa1bac7"
+	tests := []struct {
+		image   string
+		policy  string
+		exist   bool
+		want    bool
+		wantErr string
+	}{
+		{"alpine", "", false, true, ""},
+		{"alpine", "", true, false, ""},
+		{"alpine", core.PullIfNotPresent, false, true, ""},
+		{"alpine", core.PullIfNotPresent, true, false, ""},
+		{"alpine", core.PullAlways, false, true, ""},
+		{"alpine", core.PullAlways, true, true, ""},
+		{"alpine", " always ", true, true, ""},
+		{pinned, core.PullAlways, true, false, ""},
+		{pinned, core.PullAlways, false, true, ""},
+		{"alpine", core.PullNever, true, false, ""},
+		{"alpine", core.PullNever, false, false, "image alpine is not present and pull policy is never"},
+		{"alpine", "sometimes", true, false, `invalid pull policy "sometimes"`},
+	}
+	for _, tt := range tests {
+		got, err := mustPull(tt.image, tt.policy, tt.exist)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("mustPull(%s, %q, %v): got error %v, want %s", tt.image, tt.policy, tt.exist, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("mustPull(%s, %q, %v) = %v, %v, want %v", tt.image, tt.policy, tt.exist, got, err, tt.want)
+		}
+	}
+}
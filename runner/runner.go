@@ -0,0 +1,630 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+)
+
+// Runner runs jobs of a project in docker containers. It holds settings
+// shared by every job of a run and is not modified once jobs are started, so
+// jobs may read it concurrently.
+type Runner struct {
+	DockerCli core.DockerClient
+	// Pwd is the directory of the project, containing .vulcan directory.
+	Pwd        string
+	Verbose    bool
+	ToolChains string
+	Plugins    string
+	Templates  string
+	// Parallel is the maximum number of jobs running at the same time.
+	Parallel int
+	// FailFast cancels running jobs as soon as a job fails.
+	FailFast bool
+	// History records runs when it is not nil.
+	History *history.Store
+	// Trigger tells what starts runs, it is recorded in history.
+	Trigger string
+	// Executor runs jobs instead of docker host when it is not nil.
+	Executor Executor
+	// Args overrides args of jobs.
+	Args map[string]string
+	// Output receives lines written by jobs, prefixed by their id, in
+	// addition to Stderr.
+	Output io.Writer
+	// GracePeriod is the time containers are given to stop once their job is
+	// cancelled or timed out, DefaultGracePeriod when it is 0.
+	GracePeriod time.Duration
+	// Kill is done once containers must be killed without waiting for grace
+	// period. It may be nil.
+	Kill context.Context
+	// Approver decides on jobs requiring approval, they are rejected when it
+	// is nil.
+	Approver Approver
+	// Locker serializes runs and jobs of the same concurrency group, it is
+	// FileLocks when nil.
+	Locker Locker
+	// Hosts places jobs on several docker hosts instead of DockerCli when it
+	// is not nil.
+	Hosts *DockerHosts
+}
+
+// DefaultGracePeriod is the time containers are given to stop by default.
+const DefaultGracePeriod = 30 * time.Second
+
+// ErrCancelled is returned by runs whose context is cancelled.
+var ErrCancelled = errors.New("run is cancelled")
+
+// Executor runs jobs elsewhere than docker host of runner, e.g. on build
+// agents. Output of job is written to l and its result to rec.
+type Executor interface {
+	ExecuteJob(ctx context.Context, l *log.Logger, r *Runner, configFile string, job core.JobConfig, envs []string, rec *history.JobRun) error
+}
+
+const (
+	jobPending = ""
+	jobWaiting = "waiting"
+	jobRunning = "running"
+)
+
+type jobResult struct {
+	id     string
+	err    error
+	record history.JobRun
+}
+
+// ListConfigFiles returns yaml files in vulcan directory. When action is not
+// empty, only the file of that action is returned.
+func ListConfigFiles(vulCanDir, action string) ([]string, error) {
+	st, err := os.Stat(vulCanDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !st.IsDir() {
+		return nil, fmt.Errorf("%s is not directory", vulCanDir)
+	}
+
+	fileInfos, err := ioutil.ReadDir(vulCanDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, fileInfo := range fileInfos {
+		p := filepath.Join(vulCanDir, fileInfo.Name())
+		ext := filepath.Ext(p)
+		//exclude not yaml file
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		fileName := strings.TrimSuffix(fileInfo.Name(), ext)
+		if action == "" || fileName == action {
+			files = append(files, p)
+		}
+	}
+	return files, nil
+}
+
+// TriggeredFiles returns configuration files of the project whose on
+// matches trigger.
+func (r *Runner) TriggeredFiles(t core.Trigger) ([]string, error) {
+	files, err := ListConfigFiles(filepath.Join(r.Pwd, ".vulcan"), "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file in .vulcan directory: %v", err)
+	}
+	var triggered []string
+	for _, p := range files {
+		c, err := core.ReadProjectConfig(p)
+		if err != nil {
+			return nil, err
+		}
+		if c.OnConfig.Match(t) {
+			triggered = append(triggered, p)
+		}
+	}
+	return triggered, nil
+}
+
+// RunTriggered runs actions of the project whose on matches trigger.
+// Variables describing trigger are passed to jobs along with envs.
+func (r *Runner) RunTriggered(ctx context.Context, t core.Trigger, envs []string) error {
+	files, err := r.TriggeredFiles(t)
+	if err != nil {
+		return err
+	}
+	envs = append(append([]string(nil), envs...), t.Env()...)
+	triggered := *r
+	triggered.Trigger = t.String()
+	var errs []string
+	for _, p := range files {
+		log.Printf("Action: %s (%s)", strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)), t)
+		err = triggered.RunConfigFile(ctx, p, "", envs)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// RunConfigFile runs jobs of configuration file p, or only jobId and the
+// jobs it needs when jobId is not empty.
+func (r *Runner) RunConfigFile(ctx context.Context, p, jobId string, envs []string) error {
+	a, err := r.Prepare(p, jobId, envs)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return a.Run(ctx)
+}
+
+// ActionRun is a run of an action prepared by Runner.Prepare.
+type ActionRun struct {
+	runner       *Runner
+	renderDir    string
+	renderedFile string
+	config       core.ProjectConfig
+	jobId        string
+	envs         []string
+	// Record is the run recorded in history, nil when history is disabled.
+	Record *history.Run
+}
+
+// Prepare loads and renders configuration file p, then records a run of it
+// in history. The run starts with Run and must be closed.
+func (r *Runner) Prepare(p, jobId string, envs []string) (*ActionRun, error) {
+	c, err := core.LoadProjectConfig(p, r.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project configuration file: %v", err)
+	}
+	//args may hold secrets, so configuration is recorded before they are set
+	recorded, err := c.Render()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render project configuration file: %v", err)
+	}
+	if len(r.Args) > 0 {
+		c.SetArgs(r.Args)
+	}
+
+	//vexec runs jobs of the rendered configuration, so that templates
+	//do not need to be available inside containers
+	renderDir, err := ioutil.TempDir("", "vulcan-config-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	a := &ActionRun{
+		runner:       r,
+		renderDir:    renderDir,
+		renderedFile: filepath.Join(renderDir, filepath.Base(p)),
+		jobId:        jobId,
+		envs:         envs,
+	}
+	data, err := c.Render()
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to render project configuration file: %v", err)
+	}
+	err = ioutil.WriteFile(a.renderedFile, data, 0644)
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to render project configuration file: %v", err)
+	}
+
+	err = c.ExpandMatrix()
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("failed to read project configuration file: %v", err)
+	}
+	if jobId != "" && len(c.FindJobs(jobId)) == 0 {
+		a.Close()
+		return nil, fmt.Errorf("job %s not found", jobId)
+	}
+	a.config = c
+
+	if r.History == nil {
+		return a, nil
+	}
+	run := &history.Run{
+		Project:    r.Pwd,
+		Action:     strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)),
+		Job:        jobId,
+		Trigger:    r.Trigger,
+		TriggerEnv: triggerEnv(envs),
+		Status:     history.StatusRunning,
+		Config:     string(recorded),
+		Start:      time.Now(),
+	}
+	if run.Trigger == "" {
+		run.Trigger = "manual"
+	}
+	err = r.History.Create(run)
+	if err != nil {
+		log.Printf("failed to record run: %v", err)
+		return a, nil
+	}
+	log.Printf("Run: %d", run.Id)
+	a.Record = run
+	return a, nil
+}
+
+// Close removes the rendered configuration of run.
+func (a *ActionRun) Close() {
+	_ = os.RemoveAll(a.renderDir)
+}
+
+// Run runs jobs of action, then records their results in history. A run
+// whose ctx is cancelled is recorded as cancelled.
+func (a *ActionRun) Run(ctx context.Context) error {
+	r, run := a.runner, a.Record
+	err := r.runAction(ctx, a.renderedFile, a.config, a.jobId, a.envs, run)
+	if run == nil {
+		return err
+	}
+	run.End = time.Now()
+	run.Status = core.StatusSuccess
+	if err != nil {
+		run.Status = core.StatusFailure
+		if errors.Is(err, ErrCancelled) {
+			run.Status = core.StatusCancelled
+		}
+		run.Error = err.Error()
+	}
+	for _, job := range run.Jobs {
+		if len(job.Log) == 0 {
+			continue
+		}
+		if serr := r.History.SaveLog(run.Id, job.Id, job.Log); serr != nil {
+			log.Printf("failed to record log of job %s: %v", job.Id, serr)
+		}
+	}
+	if serr := r.History.Save(*run); serr != nil {
+		log.Printf("failed to record run: %v", serr)
+	}
+	return err
+}
+
+// triggerEnv returns variables describing trigger among envs, they are
+// recorded so that a run can be replayed without recording secrets.
+func triggerEnv(envs []string) []string {
+	var list []string
+	for _, kv := range envs {
+		if strings.HasPrefix(kv, "VULCAN_") {
+			list = append(list, kv)
+		}
+	}
+	return list
+}
+
+// runAction runs jobs of configuration, results of jobs are appended to run
+// when it is not nil.
+func (r *Runner) runAction(ctx context.Context, configFile string, c core.ProjectConfig, jobId string, envs []string, run *history.Run) error {
+	for id, job := range c.Jobs {
+		job.Id = strings.TrimSpace(id)
+	}
+
+	var targets []string
+	if jobId != "" {
+		targets = c.FindJobs(jobId)
+		if len(targets) == 0 {
+			return fmt.Errorf("failed to plan jobs: job %s not found", jobId)
+		}
+	}
+	order, err := core.PlanJobs(c.Jobs, targets...)
+	if err != nil {
+		return fmt.Errorf("failed to plan jobs: %v", err)
+	}
+
+	action := strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile))
+	parent, unlock, err := r.lock(ctx, log.Default(), c.Concurrency, r.groupData(action, "", r.Args, nil, envs))
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+			return ErrCancelled
+		}
+		return err
+	}
+	defer unlock()
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	runId := uint64(0)
+	if run != nil {
+		runId = run.Id
+	}
+
+	statuses := make(map[string]string)
+	records := make(map[string]history.JobRun)
+	approved := make(map[string]history.Approval)
+	results := make(chan jobResult)
+	approvals := make(chan approvalResult)
+	running, waiting := 0, 0
+	cancelled := false
+	for {
+		if parent.Err() != nil {
+			cancelled = true
+		}
+		for _, id := range order {
+			if statuses[id] != jobPending {
+				continue
+			}
+			job := c.Jobs[id]
+			needs, done := r.checkNeeds(job, statuses)
+			if !done {
+				continue
+			}
+			ok, reason, err := r.checkCondition(job, needs, cancelled)
+			if err != nil {
+				log.Printf("failed to run job: %s (%v)", id, err)
+				statuses[id] = core.StatusFailure
+				continue
+			}
+			if !ok {
+				log.Printf("skip job: %s (%s)", id, reason)
+				statuses[id] = core.StatusSkipped
+				if cancelled {
+					statuses[id] = core.StatusCancelled
+				}
+				continue
+			}
+			//waiting for approval does not take a slot of parallel jobs
+			if _, ok := approved[id]; !ok && job.Approval != nil && job.Approval.Required {
+				statuses[id] = jobWaiting
+				waiting++
+				go func(job core.JobConfig) {
+					a, err := r.waitApproval(ctx, runId, action, job)
+					approvals <- approvalResult{id: job.Id, approval: a, err: err}
+				}(*job)
+				continue
+			}
+			if running >= r.Parallel {
+				continue
+			}
+			//jobs whose condition holds once run is cancelled, such as
+			//always() or cancelled(), still run to clean up
+			jobCtx := ctx
+			if cancelled {
+				jobCtx = r.cleanupContext()
+			}
+			statuses[id] = jobRunning
+			running++
+			go func(job core.JobConfig) {
+				res := jobResult{id: job.Id}
+				res.err = r.runJobWithLog(jobCtx, configFile, job, envs, &res.record)
+				results <- res
+			}(*job)
+		}
+
+		if running == 0 && waiting == 0 {
+			break
+		}
+		var res jobResult
+		select {
+		case a := <-approvals:
+			waiting--
+			r.decide(ctx, a, statuses, records, approved)
+			continue
+		case res = <-results:
+		}
+		running--
+		if a, ok := approved[res.id]; ok {
+			res.record.Approval = &a
+		}
+		records[res.id] = res.record
+		if res.err == nil {
+			statuses[res.id] = core.StatusSuccess
+			continue
+		}
+		if res.record.Status == core.StatusCancelled {
+			statuses[res.id] = core.StatusCancelled
+			continue
+		}
+		statuses[res.id] = core.StatusFailure
+		if r.FailFast && !cancelled {
+			log.Printf("cancel remaining jobs since job %s failed", res.id)
+			cancelled = true
+			cancel()
+		}
+	}
+
+	failed, rejected, stopped := 0, 0, 0
+	for _, id := range order {
+		switch statuses[id] {
+		case core.StatusFailure:
+			failed++
+		case core.StatusRejected:
+			rejected++
+		case core.StatusCancelled:
+			stopped++
+		}
+		if run == nil {
+			continue
+		}
+		record, ok := records[id]
+		if !ok {
+			record = history.JobRun{Id: id, Status: statuses[id]}
+		}
+		run.Jobs = append(run.Jobs, record)
+	}
+	if parent.Err() != nil {
+		return ErrCancelled
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d job(s) failed", failed, len(order))
+	}
+	if rejected > 0 {
+		return fmt.Errorf("%d of %d job(s) rejected", rejected, len(order))
+	}
+	if stopped > 0 {
+		//jobs are cancelled in progress by their concurrency group
+		return ErrCancelled
+	}
+	return nil
+}
+
+// decide updates status of a job once its approval is decided. An approved job
+// is pending again, so that it runs as soon as a slot is free.
+func (r *Runner) decide(ctx context.Context, a approvalResult, statuses map[string]string, records map[string]history.JobRun, approved map[string]history.Approval) {
+	switch {
+	case a.err != nil && ctx.Err() != nil:
+		log.Printf("skip job: %s (run is cancelled)", a.id)
+		statuses[a.id] = core.StatusCancelled
+	case a.err != nil:
+		log.Printf("failed to run job: %s (%v)", a.id, a.err)
+		statuses[a.id] = core.StatusFailure
+		records[a.id] = history.JobRun{Id: a.id, Status: core.StatusFailure, Error: a.err.Error()}
+	case a.approval.Approved:
+		log.Printf("job %s is approved by %s", a.id, a.approval.By)
+		statuses[a.id] = jobPending
+		approved[a.id] = a.approval
+	default:
+		log.Printf("skip job: %s (%s)", a.id, rejection(a.approval))
+		approval := a.approval
+		statuses[a.id] = core.StatusRejected
+		records[a.id] = history.JobRun{
+			Id:       a.id,
+			Status:   core.StatusRejected,
+			Error:    rejection(a.approval),
+			Approval: &approval,
+		}
+	}
+}
+
+// cleanupContext returns context of jobs started once their run is cancelled,
+// it is done only when containers must be killed.
+func (r *Runner) cleanupContext() context.Context {
+	if r.Kill != nil {
+		return r.Kill
+	}
+	return context.Background()
+}
+
+// checkNeeds returns status of every job needed by job. done is false if one
+// of them has not finished yet.
+func (r *Runner) checkNeeds(job *core.JobConfig, statuses map[string]string) (needs map[string]string, done bool) {
+	needs = make(map[string]string)
+	for _, need := range job.Needs {
+		need = strings.TrimSpace(need)
+		switch statuses[need] {
+		case jobPending, jobWaiting, jobRunning:
+			return nil, false
+		}
+		needs[need] = statuses[need]
+	}
+	return needs, true
+}
+
+// checkCondition evaluates if condition of job against result of its needed
+// jobs, or as cancelled once run is cancelled. When job is not going to be
+// run, the reason is returned.
+func (r *Runner) checkCondition(job *core.JobConfig, needs map[string]string, cancelled bool) (bool, string, error) {
+	status := core.StatusSuccess
+	upstream := ""
+	for _, need := range job.Needs {
+		need = strings.TrimSpace(need)
+		switch needs[need] {
+		case core.StatusFailure, core.StatusRejected:
+			status = core.StatusFailure
+			upstream = need
+		case core.StatusCancelled:
+			if status != core.StatusFailure {
+				status = core.StatusCancelled
+				upstream = need
+			}
+		case core.StatusSkipped:
+			if status == core.StatusSuccess {
+				status = core.StatusSkipped
+				upstream = need
+			}
+		}
+	}
+	if cancelled {
+		status = core.StatusCancelled
+	}
+
+	ok, err := core.EvalCondition(job.If, core.ExprContext{
+		Status: status,
+		Args:   jobArgs(job),
+		Matrix: job.MatrixValues,
+		Needs:  needs,
+	})
+	if err != nil || ok {
+		return ok, "", err
+	}
+	if strings.TrimSpace(job.If) == "" && cancelled {
+		return false, "run is cancelled", nil
+	}
+	if strings.TrimSpace(job.If) == "" {
+		return false, fmt.Sprintf("upstream job %s did not succeed", upstream), nil
+	}
+	return false, fmt.Sprintf("condition %s is not satisfied", strings.TrimSpace(job.If)), nil
+}
+
+// jobArgs returns args of job, with variables of environment they refer to
+// replaced.
+func jobArgs(job *core.JobConfig) map[string]string {
+	args := make(map[string]string)
+	if job.Args != nil {
+		for k, v := range *job.Args {
+			args[k] = core.ReadEnvVariableIfHas(v)
+		}
+	}
+	return args
+}
+
+func (r *Runner) runJobWithLog(ctx context.Context, configFile string, job core.JobConfig, envs []string, rec *history.JobRun) error {
+	var out io.Writer = Stderr
+	if r.Output != nil {
+		out = io.MultiWriter(Stderr, r.Output)
+	}
+	w := newLineWriter(out, fmt.Sprintf("[%s] ", job.Id))
+	defer w.Flush()
+	l := log.New(w, "", log.LstdFlags)
+	rec.Id = job.Id
+	rec.Start = time.Now()
+	action := strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile))
+	jobCtx, unlock, err := r.lock(ctx, l, job.Concurrency, r.groupData(action, job.Id, jobArgs(&job), job.MatrixValues, envs))
+	cancelled := err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled))
+	if err == nil {
+		if r.Executor != nil {
+			err = r.Executor.ExecuteJob(jobCtx, l, r, configFile, job, envs, rec)
+		} else if r.Hosts != nil {
+			err = r.runJobOnHosts(jobCtx, l, configFile, job, envs, rec)
+		} else {
+			err = r.runJob(jobCtx, l, configFile, job, envs, rec)
+		}
+		cancelled = jobCtx.Err() != nil
+		unlock()
+	}
+	rec.End = time.Now()
+	rec.Status = core.StatusSuccess
+	if err != nil {
+		rec.Status = core.StatusFailure
+		rec.Error = err.Error()
+	}
+	if err != nil && cancelled {
+		rec.Status = core.StatusCancelled
+		l.Printf("job is cancelled: %s", job.Id)
+	} else if err != nil {
+		if r.Verbose {
+			l.Printf("failed to run job: %s", job.Name)
+		} else {
+			l.Printf("failed to run job: %s", job.Name)
+			l.Println("=== BEGIN: Error Message ===")
+			l.Printf("%v", err)
+			l.Println("=== END: Error Message ===")
+		}
+	}
+	return err
+}
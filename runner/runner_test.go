@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+)
+
+// cancelExecutor runs job build until it is cancelled and fails job lint once
+// build is started. Other jobs are recorded with whether their ctx is done.
+type cancelExecutor struct {
+	started chan struct{}
+	mu      sync.Mutex
+	ran     map[string]bool
+}
+
+func (e *cancelExecutor) ExecuteJob(ctx context.Context, l *log.Logger, r *Runner, configFile string, job core.JobConfig, envs []string, rec *history.JobRun) error {
+	switch job.Id {
+	case "build":
+		close(e.started)
+		<-ctx.Done()
+		return ctx.Err()
+	case "lint":
+		<-e.started
+		return errors.New("exit code 1")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ran[job.Id] = ctx.Err() != nil
+	return nil
+}
+
+const cleanupConfig = `jobs:
+  build:
+    run-on: alpine
+    steps:
+      - run: make
+  lint:
+    run-on: alpine
+    steps:
+      - run: lint
+  test:
+    run-on: alpine
+    needs: [build]
+    steps:
+      - run: make test
+  failed:
+    run-on: alpine
+    needs: [build]
+    if: failure()
+    steps:
+      - run: echo failed
+  cleanup:
+    run-on: alpine
+    needs: [build]
+    if: always()
+    steps:
+      - run: make clean
+  cancelled:
+    run-on: alpine
+    needs: [build]
+    if: cancelled()
+    steps:
+      - run: echo cancelled
+`
+
+func TestCancelledRunStillRunsCleanupJobs(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "ci.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(cleanupConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		failFast bool
+		wantErr  string
+	}{
+		{name: "interrupted", wantErr: ErrCancelled.Error()},
+		{name: "fail fast", failFast: true, wantErr: "job(s) failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &cancelExecutor{started: make(chan struct{}), ran: make(map[string]bool)}
+			r := &Runner{Pwd: dir, Parallel: 2, Executor: e, FailFast: tt.failFast}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if !tt.failFast {
+				go func() {
+					<-e.started
+					cancel()
+				}()
+			}
+			err := r.RunConfigFile(ctx, configFile, "", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+
+			var ran []string
+			for id, done := range e.ran {
+				ran = append(ran, id)
+				if done {
+					t.Errorf("job %s runs with a cancelled context", id)
+				}
+			}
+			sort.Strings(ran)
+			if strings.Join(ran, ",") != "cancelled,cleanup" {
+				t.Fatalf("got jobs %v run after cancel, want cleanup and cancelled", ran)
+			}
+		})
+	}
+}
+
+func TestArgsOfRunAreNotRecorded(t *testing.T) {
+	const secret = "s3cr3t-t0ken"
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "deploy.yaml")
+	config := `jobs:
+  deploy:
+    run-on: alpine
+    args:
+      token: none
+    steps:
+      - run: deploy {{.token}}
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{
+		Pwd:     dir,
+		History: &history.Store{Path: filepath.Join(dir, "history.db")},
+		Args:    map[string]string{"token": secret},
+	}
+	a, err := r.Prepare(configFile, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	rendered, err := ioutil.ReadFile(a.renderedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rendered), secret) {
+		t.Fatalf("args are not set in configuration run by jobs:\n%s", rendered)
+	}
+	run, err := r.History.Get(a.Record.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(run.Config, secret) || !strings.Contains(run.Config, "token: none") {
+		t.Fatalf("configuration is recorded with args of run:\n%s", run.Config)
+	}
+}
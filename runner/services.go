@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/locngoxuan/vulcan/core"
+)
+
+var invalidNetworkChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// startServices creates a network dedicated to job, then starts services of
+// job on it and waits until they are healthy. Returned cleanup removes
+// service containers and the network, it must be called even if an error is
+// returned.
+func (r *Runner) startServices(ctx context.Context, l *log.Logger, job core.JobConfig) (networkName string, cleanup func(), err error) {
+	cli := r.DockerCli.Client
+	var containers []string
+	networkId := ""
+	cleanup = func() {
+		for _, id := range containers {
+			core.RemoveAfterDone(cli, id)
+		}
+		if networkId != "" {
+			core.RemoveNetworkAfterDone(cli, networkId)
+		}
+	}
+	if len(job.Services) == 0 {
+		return "", cleanup, nil
+	}
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	networkName = fmt.Sprintf("vulcan-%s-%s",
+		strings.Trim(invalidNetworkChars.ReplaceAllString(job.Id, "-"), "-"), hex.EncodeToString(suffix))
+	resp, err := cli.NetworkCreate(ctx, networkName, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Labels:         map[string]string{"vulcan.job": job.Id},
+	})
+	if err != nil {
+		return "", cleanup, fmt.Errorf(`failed to create network: %v`, err)
+	}
+	networkId = resp.ID
+	l.Printf("Network: %s", networkName)
+
+	names := make([]string, 0, len(job.Services))
+	for name := range job.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ids := make(map[string]string)
+	for _, name := range names {
+		err = r.pullImage(ctx, l, job.Services[name].Image, job.Pull)
+		if err != nil {
+			return "", cleanup, fmt.Errorf(`failed to start service %s: %v`, name, err)
+		}
+		id, err := r.createService(ctx, networkName, name, job.Services[name])
+		if id != "" {
+			containers = append(containers, id)
+			ids[name] = id
+		}
+		if err != nil {
+			return "", cleanup, fmt.Errorf(`failed to start service %s: %v`, name, err)
+		}
+		l.Printf("Service: %s (%s)", name, job.Services[name].Image)
+	}
+
+	for _, name := range names {
+		err = r.waitService(ctx, ids[name])
+		if err != nil {
+			return "", cleanup, fmt.Errorf(`service %s is not ready: %v`, name, err)
+		}
+		l.Printf("Service: %s is ready", name)
+	}
+	return networkName, cleanup, nil
+}
+
+func (r *Runner) createService(ctx context.Context, networkName, name string, service *core.ServiceConfig) (string, error) {
+	cli := r.DockerCli.Client
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(service.Ports)
+	if err != nil {
+		return "", err
+	}
+
+	containerConfig := &container.Config{
+		Image:        service.Image,
+		Env:          service.Env.List(),
+		ExposedPorts: exposedPorts,
+	}
+	if hc := service.Healthcheck; hc != nil && strings.TrimSpace(hc.Command) != "" {
+		interval, err := core.ParseDuration(hc.Interval)
+		if err != nil {
+			return "", fmt.Errorf(`invalid healthcheck interval: %v`, err)
+		}
+		timeout, err := core.ParseDuration(hc.Timeout)
+		if err != nil {
+			return "", fmt.Errorf(`invalid healthcheck timeout: %v`, err)
+		}
+		containerConfig.Healthcheck = &container.HealthConfig{
+			Test:     []string{"CMD-SHELL", hc.Command},
+			Interval: interval,
+			Timeout:  timeout,
+			Retries:  hc.Retries,
+		}
+	}
+	hostConfig := &container.HostConfig{
+		PortBindings: portBindings,
+	}
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			networkName: {Aliases: []string{name}},
+		},
+	}
+
+	cont, err := cli.ContainerCreate(ctx, containerConfig, hostConfig, networkingConfig, nil, "")
+	if err != nil {
+		return "", err
+	}
+	err = cli.ContainerStart(ctx, cont.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return cont.ID, err
+	}
+	return cont.ID, nil
+}
+
+// waitService waits until service container is healthy. A service without
+// healthcheck is ready as soon as it is running.
+func (r *Runner) waitService(ctx context.Context, id string) error {
+	cli := r.DockerCli.Client
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		inspect, err := cli.ContainerInspect(ctx, id)
+		if err != nil {
+			return err
+		}
+		state := inspect.State
+		if state == nil || !state.Running {
+			return fmt.Errorf(`container is not running`)
+		}
+		if state.Health == nil {
+			return nil
+		}
+		switch state.Health.Status {
+		case types.Healthy:
+			return nil
+		case types.Unhealthy:
+			msg := "container is unhealthy"
+			if n := len(state.Health.Log); n > 0 {
+				msg = fmt.Sprintf("%s: %s", msg, strings.TrimSpace(state.Health.Log[n-1].Output))
+			}
+			return errors.New(msg)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
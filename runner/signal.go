@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ExitCancelled is the exit code of commands whose run is cancelled, as a
+// shell reports a process interrupted by SIGINT.
+const ExitCancelled = 130
+
+// SignalContext returns ctx, cancelled by the first interrupt or termination
+// signal, and kill, cancelled by the second one. Runs are cancelled with ctx
+// and containers are killed without grace period with kill. stop releases
+// signals.
+func SignalContext() (ctx, kill context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	kill, forceKill := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		log.Printf("cancelling run, containers are given their grace period to stop. Interrupt again to kill them")
+		cancel()
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		log.Printf("killing containers")
+		forceKill()
+	}()
+	stop = func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+		forceKill()
+	}
+	return ctx, kill, stop
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/scm"
+)
+
+// TriggerRunner checks out commits into isolated directories of Workspace,
+// then runs actions of those commits matching the trigger.
+type TriggerRunner struct {
+	Runner    Runner
+	Workspace string
+	// Keep leaves checked out commits in workspace after running.
+	Keep bool
+	// DryRun only prints triggered actions.
+	DryRun bool
+	Envs   []string
+}
+
+// Run checks out commit of trigger from repo and runs actions triggered by it.
+func (tr *TriggerRunner) Run(ctx context.Context, repo scm.Repository, t core.Trigger) error {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	commit := t.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	dir := filepath.Join(tr.Workspace, fmt.Sprintf("%s-%s", commit, hex.EncodeToString(suffix)))
+	if !tr.Keep {
+		defer os.RemoveAll(dir)
+	}
+	err := repo.Checkout(t.Ref, t.Commit, dir)
+	if err != nil {
+		return fmt.Errorf("failed to check out: %v", err)
+	}
+
+	r := tr.Runner
+	r.Pwd = dir
+	if tr.DryRun {
+		files, err := r.TriggeredFiles(t)
+		if err != nil {
+			return err
+		}
+		for _, p := range files {
+			log.Printf("Action: %s (%s)", strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)), t)
+		}
+		return nil
+	}
+	return r.RunTriggered(ctx, t, tr.Envs)
+}
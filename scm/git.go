@@ -0,0 +1,136 @@
+package scm
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/locngoxuan/vulcan/core"
+)
+
+// Namespaces of references looked at for changes. Pull requests are fetched
+// by git servers such as Gitea under refs/pull and GitLab under
+// refs/merge-requests.
+var refPrefixes = []string{
+	"refs/heads/",
+	"refs/tags/",
+	"refs/pull/",
+	"refs/merge-requests/",
+}
+
+// Repository is a local git repository, either bare or with a working tree.
+type Repository struct {
+	Path string
+}
+
+func (r Repository) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", r.Path}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf(`git %s: %v: %s`, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Refs returns commits of branches, tags and pull requests by reference name.
+// Annotated tags are peeled to the commit they point to.
+func (r Repository) Refs() (map[string]string, error) {
+	args := []string{"for-each-ref", "--format=%(objectname) %(*objectname) %(refname)"}
+	out, err := r.git(append(args, refPrefixes...)...)
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 2:
+			refs[fields[1]] = fields[0]
+		case 3:
+			refs[fields[2]] = fields[1]
+		}
+	}
+	return refs, nil
+}
+
+// DefaultBranch returns the branch HEAD of repository refers to.
+func (r Repository) DefaultBranch() string {
+	out, err := r.git("symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// Checkout fetches ref of repository into a new repository at dir and checks
+// out commit there, leaving repository untouched.
+func (r Repository) Checkout(ref, commit, dir string) error {
+	//ref and commit may come from a webhook, they must not be taken as options
+	if strings.HasPrefix(commit, "-") {
+		return fmt.Errorf("invalid commit %s", commit)
+	}
+	_, err := Repository{}.git("init", "--quiet", dir)
+	if err != nil {
+		return err
+	}
+	w := Repository{Path: dir}
+	_, err = w.git("fetch", "--quiet", "--no-tags", "--", r.Path, ref)
+	if err != nil {
+		return err
+	}
+	_, err = w.git("checkout", "--quiet", "--detach", commit)
+	return err
+}
+
+// Changes returns triggers of references created or updated from old to new.
+// Pull requests are considered merged into defaultBranch.
+func Changes(old, new map[string]string, defaultBranch string) []core.Trigger {
+	names := make([]string, 0, len(new))
+	for name := range new {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var triggers []core.Trigger
+	for _, name := range names {
+		commit := new[name]
+		if old[name] == commit {
+			continue
+		}
+		if t, ok := NewTrigger(name, commit, defaultBranch); ok {
+			triggers = append(triggers, t)
+		}
+	}
+	return triggers
+}
+
+// NewTrigger returns the trigger of reference ref pointing to commit. False
+// is returned if ref is not a branch, a tag or a pull request.
+func NewTrigger(ref, commit, defaultBranch string) (core.Trigger, bool) {
+	t := core.Trigger{Ref: ref, Commit: commit}
+	switch {
+	case strings.HasPrefix(ref, "refs/heads/"):
+		t.Event = core.EventPush
+		t.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	case strings.HasPrefix(ref, "refs/tags/"):
+		t.Event = core.EventTag
+		t.Tag = strings.TrimPrefix(ref, "refs/tags/")
+	case strings.HasPrefix(ref, "refs/pull/") || strings.HasPrefix(ref, "refs/merge-requests/"):
+		// only the head of a pull request is a change, not its merge ref
+		parts := strings.Split(ref, "/")
+		if len(parts) != 4 || parts[3] != "head" {
+			return t, false
+		}
+		t.Event = core.EventPullRequest
+		t.PullRequest = parts[2]
+		t.Branch = defaultBranch
+	default:
+		return t, false
+	}
+	return t, true
+}
@@ -0,0 +1,79 @@
+package scm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/locngoxuan/vulcan/core"
+)
+
+func TestNewTrigger(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want core.Trigger
+		ok   bool
+	}{
+		{"refs/heads/main", core.Trigger{Event: core.EventPush, Ref: "refs/heads/main", Commit: "c1", Branch: "main"}, true},
+		{"refs/heads/feature/login", core.Trigger{Event: core.EventPush, Ref: "refs/heads/feature/login", Commit: "c1", Branch: "feature/login"}, true},
+		{"refs/tags/v1.0.0", core.Trigger{Event: core.EventTag, Ref: "refs/tags/v1.0.0", Commit: "c1", Tag: "v1.0.0"}, true},
+		{"refs/pull/42/head", core.Trigger{Event: core.EventPullRequest, Ref: "refs/pull/42/head", Commit: "c1", Branch: "master", PullRequest: "42"}, true},
+		{"refs/merge-requests/7/head", core.Trigger{Event: core.EventPullRequest, Ref: "refs/merge-requests/7/head", Commit: "c1", Branch: "master", PullRequest: "7"}, true},
+		{"refs/pull/42/merge", core.Trigger{}, false},
+		{"refs/notes/commits", core.Trigger{}, false},
+		{"HEAD", core.Trigger{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := NewTrigger(tt.ref, "c1", "master")
+		if ok != tt.ok {
+			t.Errorf("%s: got ok %v, want %v", tt.ref, ok, tt.ok)
+			continue
+		}
+		if ok && got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	old := map[string]string{
+		"refs/heads/main":    "c1",
+		"refs/heads/dev":     "c2",
+		"refs/tags/v1.0.0":   "c1",
+		"refs/pull/3/head":   "c3",
+		"refs/heads/removed": "c4",
+	}
+	tests := []struct {
+		name string
+		new  map[string]string
+		want []string
+	}{
+		{"nothing changed", old, nil},
+		{
+			name: "branch updated",
+			new:  map[string]string{"refs/heads/main": "c5", "refs/heads/dev": "c2", "refs/tags/v1.0.0": "c1", "refs/pull/3/head": "c3"},
+			want: []string{"push refs/heads/main (c5)"},
+		},
+		{
+			name: "refs created in order of name",
+			new: map[string]string{
+				"refs/heads/main": "c1", "refs/heads/dev": "c2", "refs/tags/v1.0.0": "c1", "refs/pull/3/head": "c3",
+				"refs/tags/v1.1.0": "c6", "refs/heads/feature": "c6", "refs/pull/4/head": "c7", "refs/pull/4/merge": "c8",
+			},
+			want: []string{"push refs/heads/feature (c6)", "pull_request refs/pull/4/head (c7)", "tag refs/tags/v1.1.0 (c6)"},
+		},
+		{
+			name: "pull request updated",
+			new:  map[string]string{"refs/pull/3/head": "c9"},
+			want: []string{"pull_request refs/pull/3/head (c9)"},
+		},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, trigger := range Changes(old, tt.new, "main") {
+			got = append(got, trigger.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
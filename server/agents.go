@@ -0,0 +1,427 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/history"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+const (
+	// PollTimeout is how long a poll of an agent waits for a job.
+	PollTimeout = 30 * time.Second
+	// HeartbeatInterval is how often an agent tells a job is still running.
+	HeartbeatInterval = 10 * time.Second
+	// AgentTimeout is how long a job is kept without heartbeat before its
+	// agent is considered lost.
+	AgentTimeout = 1 * time.Minute
+	// cancelTimeout is how long a cancelled job waits for its agent to
+	// report.
+	cancelTimeout = 1 * time.Minute
+)
+
+// AgentInfo is sent by an agent to register.
+type AgentInfo struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"labels,omitempty"`
+	// Capacity is the maximum number of jobs the agent runs at the same time.
+	Capacity int `json:"capacity"`
+}
+
+// AgentStatus is an agent known by dispatcher.
+type AgentStatus struct {
+	AgentInfo
+	Id       string    `json:"id"`
+	Running  int       `json:"running"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Assignment is a job assigned to an agent. Config is the rendered
+// configuration of action, project directory is downloaded apart.
+type Assignment struct {
+	Id     string   `json:"id"`
+	Action string   `json:"action"`
+	Job    string   `json:"job"`
+	Config string   `json:"config"`
+	Envs   []string `json:"envs,omitempty"`
+}
+
+// Heartbeat tells an agent whether its job is cancelled.
+type Heartbeat struct {
+	Cancelled bool `json:"cancelled"`
+}
+
+type agentState struct {
+	AgentStatus
+	labels map[string]struct{}
+}
+
+type task struct {
+	Assignment
+	labels []string
+	pwd    string
+	agent  string
+	out    io.Writer
+
+	mu  sync.Mutex
+	log bytes.Buffer
+
+	lastSeen  time.Time
+	cancelled bool
+	finished  bool
+	done      chan history.JobRun
+}
+
+func (t *task) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.log.Write(p)
+	t.mu.Unlock()
+	return t.out.Write(p)
+}
+
+// Dispatcher queues jobs until a build agent matching their labels pulls
+// them. It is an executor of runner, and serves agents under /agent/.
+type Dispatcher struct {
+	// Token authenticates agents, no agent is accepted without it.
+	Token string
+
+	mu      sync.Mutex
+	seq     uint64
+	agents  map[string]*agentState
+	pending []*task
+	tasks   map[string]*task
+	changed chan struct{}
+}
+
+// NewDispatcher returns a dispatcher watching agents until ctx is done.
+func NewDispatcher(ctx context.Context, token string) *Dispatcher {
+	d := &Dispatcher{
+		Token:   token,
+		agents:  make(map[string]*agentState),
+		tasks:   make(map[string]*task),
+		changed: make(chan struct{}),
+	}
+	go d.watch(ctx)
+	return d
+}
+
+// notify wakes up polling agents. It must be called with mu locked.
+func (d *Dispatcher) notify() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// watch forgets agents and fails jobs whose agent stopped to report.
+func (d *Dispatcher) watch(ctx context.Context) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		d.mu.Lock()
+		for id, a := range d.agents {
+			if now.Sub(a.LastSeen) > AgentTimeout && a.Running == 0 {
+				log.Printf("Agent: %s (%s) is lost", a.Name, id)
+				delete(d.agents, id)
+			}
+		}
+		for _, t := range d.tasks {
+			if t.agent != "" && now.Sub(t.lastSeen) > AgentTimeout {
+				d.finish(t, history.JobRun{
+					Status: core.StatusFailure,
+					Error:  fmt.Sprintf("agent %s is lost", d.agentName(t.agent)),
+				})
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *Dispatcher) agentName(id string) string {
+	if a, ok := d.agents[id]; ok {
+		return a.Name
+	}
+	return id
+}
+
+// Agents returns registered agents sorted by name.
+func (d *Dispatcher) Agents() []AgentStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := make([]AgentStatus, 0, len(d.agents))
+	for _, a := range d.agents {
+		list = append(list, a.AgentStatus)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// ExecuteJob queues job until an agent runs it, output received from agent is
+// written to l.
+func (d *Dispatcher) ExecuteJob(ctx context.Context, l *log.Logger, r *runner.Runner, configFile string, job core.JobConfig, envs []string, rec *history.JobRun) error {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	t := &task{
+		Assignment: Assignment{
+			Action: strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile)),
+			Job:    job.Id,
+			Config: string(data),
+			Envs:   envs,
+		},
+		labels: job.Labels,
+		pwd:    r.Pwd,
+		out:    l.Writer(),
+		done:   make(chan history.JobRun, 1),
+	}
+	d.mu.Lock()
+	d.seq++
+	t.Id = strconv.FormatUint(d.seq, 10)
+	d.tasks[t.Id] = t
+	d.pending = append(d.pending, t)
+	d.notify()
+	d.mu.Unlock()
+	if len(job.Labels) > 0 {
+		l.Printf("Job: %s is waiting for an agent labelled %s", job.Id, strings.Join(job.Labels, ", "))
+	} else {
+		l.Printf("Job: %s is waiting for an agent", job.Id)
+	}
+
+	var res history.JobRun
+	select {
+	case res = <-t.done:
+	case <-ctx.Done():
+		if d.cancel(t) {
+			return ctx.Err()
+		}
+		select {
+		case res = <-t.done:
+		case <-time.After(cancelTimeout):
+			d.mu.Lock()
+			d.finish(t, history.JobRun{})
+			d.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	t.mu.Lock()
+	rec.Log = append([]byte(nil), t.log.Bytes()...)
+	t.mu.Unlock()
+	rec.ExitCode = res.ExitCode
+	rec.Steps = res.Steps
+	rec.Image = res.Image
+	rec.Digest = res.Digest
+	rec.Host = res.Host
+	if res.Status == core.StatusSuccess {
+		return nil
+	}
+	if res.Error == "" {
+		return fmt.Errorf(`exit code %v`, res.ExitCode)
+	}
+	return errors.New(res.Error)
+}
+
+// cancel removes task from queue if no agent pulled it yet, else asks its
+// agent to stop it. It returns true if task was removed.
+func (d *Dispatcher) cancel(t *task) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, p := range d.pending {
+		if p == t {
+			d.pending = append(d.pending[:i], d.pending[i+1:]...)
+			delete(d.tasks, t.Id)
+			return true
+		}
+	}
+	t.cancelled = true
+	return false
+}
+
+// finish records result of task. It must be called with mu locked.
+func (d *Dispatcher) finish(t *task, res history.JobRun) {
+	if t.finished {
+		return
+	}
+	t.finished = true
+	delete(d.tasks, t.Id)
+	if a, ok := d.agents[t.agent]; ok {
+		a.Running--
+	}
+	t.done <- res
+	d.notify()
+}
+
+// assign pulls the first queued task agent is able to run. It must be called
+// with mu locked.
+func (d *Dispatcher) assign(a *agentState) *task {
+	if a.Running >= a.Capacity {
+		return nil
+	}
+	for i, t := range d.pending {
+		if !a.match(t.labels) {
+			continue
+		}
+		d.pending = append(d.pending[:i], d.pending[i+1:]...)
+		t.agent = a.Id
+		t.lastSeen = time.Now()
+		a.Running++
+		return t
+	}
+	return nil
+}
+
+func (a *agentState) match(labels []string) bool {
+	for _, label := range labels {
+		if _, ok := a.labels[strings.TrimSpace(label)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP serves the agent protocol:
+//
+//	POST /agent/register              registers an agent, returns its id
+//	GET  /agent/poll?agent=<id>       waits for a job assigned to agent
+//	GET  /agent/jobs/<id>/workspace   downloads project directory of job
+//	POST /agent/jobs/<id>/log         streams output of job
+//	POST /agent/jobs/<id>/heartbeat   tells job is running, returns if it is cancelled
+//	POST /agent/jobs/<id>/result      reports result of job
+func (d *Dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(d.Token, r) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/agent"), "/")
+	switch {
+	case path == "register" && r.Method == http.MethodPost:
+		d.register(w, r)
+	case path == "poll" && r.Method == http.MethodGet:
+		d.poll(w, r)
+	case strings.HasPrefix(path, "jobs/"):
+		parts := strings.Split(strings.TrimPrefix(path, "jobs/"), "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		d.mu.Lock()
+		t, ok := d.tasks[parts[0]]
+		assigned := ok && t.agent != ""
+		if assigned {
+			t.lastSeen = time.Now()
+		}
+		d.mu.Unlock()
+		if !assigned {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+		switch {
+		case parts[1] == "workspace" && r.Method == http.MethodGet:
+			w.Header().Set("Content-Type", "application/gzip")
+			if err := WriteArchive(w, t.pwd); err != nil {
+				log.Printf("Agent: failed to send workspace of job %s: %v", t.Job, err)
+			}
+		case parts[1] == "log" && r.Method == http.MethodPost:
+			_, _ = io.Copy(t, r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		case parts[1] == "heartbeat" && r.Method == http.MethodPost:
+			d.mu.Lock()
+			cancelled := t.cancelled
+			d.mu.Unlock()
+			writeJSON(w, http.StatusOK, Heartbeat{Cancelled: cancelled})
+		case parts[1] == "result" && r.Method == http.MethodPost:
+			var res history.JobRun
+			if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+				http.Error(w, fmt.Sprintf("invalid result: %v", err), http.StatusBadRequest)
+				return
+			}
+			d.mu.Lock()
+			d.finish(t, res)
+			d.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *Dispatcher) register(w http.ResponseWriter, r *http.Request) {
+	var info AgentInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		http.Error(w, fmt.Sprintf("invalid agent: %v", err), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(info.Name) == "" || info.Capacity < 1 {
+		http.Error(w, "name and capacity of agent are required", http.StatusBadRequest)
+		return
+	}
+	a := &agentState{
+		AgentStatus: AgentStatus{AgentInfo: info, LastSeen: time.Now()},
+		labels:      make(map[string]struct{}),
+	}
+	for _, label := range info.Labels {
+		a.labels[strings.TrimSpace(label)] = struct{}{}
+	}
+	d.mu.Lock()
+	d.seq++
+	a.Id = fmt.Sprintf("%s-%d", info.Name, d.seq)
+	d.agents[a.Id] = a
+	d.mu.Unlock()
+	log.Printf("Agent: %s registered with labels [%s] and capacity %d", a.Id, strings.Join(info.Labels, ", "), info.Capacity)
+	writeJSON(w, http.StatusOK, map[string]string{"id": a.Id})
+}
+
+func (d *Dispatcher) poll(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("agent")
+	timer := time.NewTimer(PollTimeout)
+	defer timer.Stop()
+	for {
+		d.mu.Lock()
+		a, ok := d.agents[id]
+		if !ok {
+			d.mu.Unlock()
+			http.Error(w, "agent not registered", http.StatusNotFound)
+			return
+		}
+		a.LastSeen = time.Now()
+		t := d.assign(a)
+		changed := d.changed
+		d.mu.Unlock()
+		if t != nil {
+			log.Printf("Agent: job %s of action %s is assigned to %s", t.Job, t.Action, a.Id)
+			writeJSON(w, http.StatusOK, t.Assignment)
+			return
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/locngoxuan/vulcan/history"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+// maxRequestSize limits size of API requests read in memory.
+const maxRequestSize = 1 << 20
+
+// RunRequest asks to run an action of a project, or only a job of it.
+type RunRequest struct {
+	// Project is the name of a registered project, it may be omitted when a
+	// single project is registered.
+	Project string            `json:"project,omitempty"`
+	Action  string            `json:"action"`
+	Job     string            `json:"job,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Args    map[string]string `json:"args,omitempty"`
+}
+
+// API triggers, queries and cancels runs over HTTP:
+//
+//	GET    /runs                  lists runs, filtered by project, action, job, status and limit
+//	POST   /runs                  runs an action described by a RunRequest
+//	GET    /runs/<id>             returns a run
+//	GET    /runs/<id>/logs        streams output of a run, or of a job of it with ?job=
+//	DELETE /runs/<id>             cancels a run
+//	GET    /runs/<id>/approvals   lists jobs of a run waiting for approval
+//	POST   /runs/<id>/approvals   approves or rejects a job described by a Decision
+//	GET    /projects              lists registered projects and their actions
+type API struct {
+	// Token authenticates requests. Without Token, only approvers are
+	// authenticated.
+	Token string
+	// Approvers maps names of approvers to their token, which authenticates
+	// requests as well. Only approvers decide on jobs waiting for approval.
+	Approvers map[string]string
+	// Projects maps names of projects to their directory.
+	Projects map[string]string
+	// Runner runs requested actions, its History must be set.
+	Runner runner.Runner
+	// Envs are passed to every run along with variables of requests.
+	Envs []string
+
+	ctx       context.Context
+	mu        sync.Mutex
+	active    map[uint64]*activeRun
+	approvals map[string]*pendingApproval
+}
+
+type activeRun struct {
+	cancel context.CancelFunc
+	output *liveLog
+}
+
+// NewAPI returns an API whose runs are cancelled once ctx is done. Jobs of
+// its runs requiring approval are approved through the API.
+func NewAPI(ctx context.Context, token string, projects map[string]string, r runner.Runner, envs []string) *API {
+	a := &API{
+		Token:     token,
+		Projects:  projects,
+		Runner:    r,
+		Envs:      envs,
+		ctx:       ctx,
+		active:    make(map[uint64]*activeRun),
+		approvals: make(map[string]*pendingApproval),
+	}
+	a.Runner.Approver = a
+	return a
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(a.Token, r) && a.approver(r) == "" {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+	if r.URL.Path == "/projects" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		a.projects(w)
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs"), "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			a.list(w, r)
+		case http.MethodPost:
+			a.create(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "logs" && parts[1] != "approvals") {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	switch {
+	case len(parts) == 2 && parts[1] == "logs" && r.Method == http.MethodGet:
+		a.logs(w, r, id)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, a.pending(id))
+	case len(parts) == 2 && parts[1] == "approvals" && r.Method == http.MethodPost:
+		a.decide(w, r, id)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		run, err := a.Runner.History.Get(id)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		a.cancel(w, id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func statusOf(err error) int {
+	if errors.Is(err, history.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func (a *API) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := history.Filter{
+		Project: q.Get("project"),
+		Action:  q.Get("action"),
+		Job:     q.Get("job"),
+		Status:  q.Get("status"),
+		Limit:   20,
+	}
+	if dir, ok := a.Projects[f.Project]; ok {
+		f.Project = dir
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", limit))
+			return
+		}
+		f.Limit = n
+	}
+	runs, err := a.Runner.History.List(f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if runs == nil {
+		runs = []history.Run{}
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// Project is a project registered to API.
+type Project struct {
+	Name    string   `json:"name"`
+	Dir     string   `json:"dir"`
+	Actions []string `json:"actions"`
+}
+
+func (a *API) projects(w http.ResponseWriter) {
+	list := make([]Project, 0, len(a.Projects))
+	for name, dir := range a.Projects {
+		p := Project{Name: name, Dir: dir, Actions: []string{}}
+		files, err := runner.ListConfigFiles(filepath.Join(dir, ".vulcan"), "")
+		if err != nil {
+			log.Printf("API: failed to list actions of project %s: %v", name, err)
+		}
+		for _, f := range files {
+			p.Actions = append(p.Actions, strings.TrimSuffix(filepath.Base(f), filepath.Ext(f)))
+		}
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+// project returns directory of project named name.
+func (a *API) project(name string) (string, error) {
+	if name == "" && len(a.Projects) == 1 {
+		for _, dir := range a.Projects {
+			return dir, nil
+		}
+	}
+	if name == "" {
+		return "", errors.New("project is missing")
+	}
+	dir, ok := a.Projects[name]
+	if !ok {
+		return "", fmt.Errorf("project %s is not registered", name)
+	}
+	return dir, nil
+}
+
+func (a *API) create(w http.ResponseWriter, r *http.Request) {
+	var req RunRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	if req.Action = strings.TrimSpace(req.Action); req.Action == "" {
+		writeError(w, http.StatusBadRequest, errors.New("action is missing"))
+		return
+	}
+	dir, err := a.project(strings.TrimSpace(req.Project))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	files, err := runner.ListConfigFiles(filepath.Join(dir, ".vulcan"), req.Action)
+	if err != nil || len(files) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("action %s not found", req.Action))
+		return
+	}
+
+	output := newLiveLog()
+	rr := a.Runner
+	rr.Pwd = dir
+	rr.Trigger = "api"
+	rr.Args = req.Args
+	rr.Output = output
+	envs := append([]string(nil), a.Envs...)
+	keys := make([]string, 0, len(req.Env))
+	for k := range req.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		envs = append(envs, fmt.Sprintf("%s=%s", k, req.Env[k]))
+	}
+
+	ar, err := rr.Prepare(files[0], strings.TrimSpace(req.Job), envs)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if ar.Record == nil {
+		ar.Close()
+		writeError(w, http.StatusInternalServerError, errors.New("failed to record run"))
+		return
+	}
+	run := *ar.Record
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.mu.Lock()
+	a.active[run.Id] = &activeRun{cancel: cancel, output: output}
+	a.mu.Unlock()
+	go func() {
+		defer cancel()
+		defer ar.Close()
+		err := ar.Run(ctx)
+		a.mu.Lock()
+		delete(a.active, run.Id)
+		a.mu.Unlock()
+		output.Close()
+		if err != nil {
+			log.Printf("API: run %d failed: %v", run.Id, err)
+		}
+	}()
+	log.Printf("API: run %d of action %s started", run.Id, run.Action)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (a *API) cancel(w http.ResponseWriter, id uint64) {
+	a.mu.Lock()
+	active, ok := a.active[id]
+	a.mu.Unlock()
+	if ok {
+		active.cancel()
+		log.Printf("API: run %d is cancelled", id)
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"cancelled": true})
+		return
+	}
+	if _, err := a.Runner.History.Get(id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeError(w, http.StatusConflict, fmt.Errorf("run %d is not running", id))
+}
+
+// logs streams output of a running run as it is written, or writes the
+// recorded output of jobs of a finished run. Lines are prefixed by job id in
+// both cases.
+func (a *API) logs(w http.ResponseWriter, r *http.Request, id uint64) {
+	job := r.URL.Query().Get("job")
+	a.mu.Lock()
+	active, ok := a.active[id]
+	a.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if ok {
+		flusher, _ := w.(http.Flusher)
+		offset := 0
+		for {
+			data, closed, changed := active.output.next(offset)
+			offset += len(data)
+			writeLines(w, data, job, "")
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if closed {
+				return
+			}
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	run, err := a.Runner.History.Get(id)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	for _, j := range run.Jobs {
+		if job != "" && j.Id != job {
+			continue
+		}
+		data, err := a.Runner.History.Log(run.Id, j.Id)
+		if err != nil {
+			log.Printf("API: failed to read log of job %s in run %d: %v", j.Id, run.Id, err)
+			continue
+		}
+		writeLines(w, data, "", fmt.Sprintf("[%s] ", j.Id))
+	}
+}
+
+// writeLines writes lines of data to w. Lines are kept only if they are
+// prefixed by job when it is not empty, and are prefixed by prefix.
+func writeLines(w http.ResponseWriter, data []byte, job, prefix string) {
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+		if job != "" && !bytes.HasPrefix(line, []byte("["+job+"] ")) {
+			continue
+		}
+		_, _ = w.Write([]byte(prefix))
+		_, _ = w.Write(line)
+		if line[len(line)-1] != '\n' {
+			_, _ = w.Write([]byte("\n"))
+		}
+	}
+}
+
+// liveLog keeps output of a run while it is written, so that it can be
+// followed by several readers.
+type liveLog struct {
+	mu      sync.Mutex
+	data    []byte
+	closed  bool
+	changed chan struct{}
+}
+
+func newLiveLog() *liveLog {
+	return &liveLog{changed: make(chan struct{})}
+}
+
+func (l *liveLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.data = append(l.data, p...)
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// Close tells readers that no more output is written.
+func (l *liveLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// next returns output written after offset, whether log is closed and a
+// channel closed once more output is written.
+func (l *liveLog) next(offset int) ([]byte, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.data[offset:], l.closed, l.changed
+}
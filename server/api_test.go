@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+func TestAPIAuthentication(t *testing.T) {
+	tests := []struct {
+		token  string
+		bearer string
+		status int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer guess", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		srv := httptest.NewServer(NewAPI(ctx, tt.token, nil, runner.Runner{}, nil))
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/projects", nil)
+		if tt.bearer != "" {
+			req.Header.Set("Authorization", tt.bearer)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.Close()
+		cancel()
+		if resp.StatusCode != tt.status {
+			t.Errorf("token %q, authorization %q: got status %d, want %d", tt.token, tt.bearer, resp.StatusCode, tt.status)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/locngoxuan/vulcan/history"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+// Decision approves or rejects a job waiting for approval. Job may be omitted
+// when a single job of the run is waiting. The approver deciding is the one
+// whose token authenticates the request.
+type Decision struct {
+	Job      string `json:"job,omitempty"`
+	Approved bool   `json:"approved"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type pendingApproval struct {
+	req      runner.ApprovalRequest
+	decision chan history.Approval
+}
+
+func approvalKey(runId uint64, job string) string {
+	return fmt.Sprintf("%d/%s", runId, job)
+}
+
+// Approve waits until a decision on job of req is posted to
+// /runs/<id>/approvals, so that API approves jobs of the runs it serves.
+func (a *API) Approve(ctx context.Context, req runner.ApprovalRequest) (history.Approval, error) {
+	p := &pendingApproval{
+		req:      req,
+		decision: make(chan history.Approval, 1),
+	}
+	key := approvalKey(req.RunId, req.Job)
+	a.mu.Lock()
+	a.approvals[key] = p
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		if a.approvals[key] == p {
+			delete(a.approvals, key)
+		}
+		a.mu.Unlock()
+	}()
+	log.Printf("API: job %s of run %d is waiting for approval", req.Job, req.RunId)
+	select {
+	case d := <-p.decision:
+		return d, nil
+	case <-ctx.Done():
+		return history.Approval{}, ctx.Err()
+	}
+}
+
+// pending returns jobs of run waiting for approval.
+func (a *API) pending(id uint64) []runner.ApprovalRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list := make([]runner.ApprovalRequest, 0)
+	for _, p := range a.approvals {
+		if p.req.RunId == id {
+			list = append(list, p.req)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Job < list[j].Job
+	})
+	return list
+}
+
+func (a *API) decide(w http.ResponseWriter, r *http.Request, id uint64) {
+	by := a.approver(r)
+	if by == "" {
+		writeError(w, http.StatusForbidden, errors.New("token of an approver is required"))
+		return
+	}
+	var d Decision
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&d); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid decision: %v", err))
+		return
+	}
+	if d.Job = strings.TrimSpace(d.Job); d.Job == "" {
+		pending := a.pending(id)
+		if len(pending) != 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("job is missing, %d job(s) of run %d are waiting for approval", len(pending), id))
+			return
+		}
+		d.Job = pending[0].Job
+	}
+
+	key := approvalKey(id, d.Job)
+	a.mu.Lock()
+	p, ok := a.approvals[key]
+	if ok && p.req.Allows(by) {
+		delete(a.approvals, key)
+	}
+	a.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s of run %d is not waiting for approval", d.Job, id))
+		return
+	}
+	if !p.req.Allows(by) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s is not an approver of job %s", by, d.Job))
+		return
+	}
+	approval := history.Approval{
+		Approved:  d.Approved,
+		By:        by,
+		Comment:   strings.TrimSpace(d.Comment),
+		Requested: p.req.Requested,
+		Decided:   time.Now(),
+	}
+	p.decision <- approval
+	verb := "rejected"
+	if d.Approved {
+		verb = "approved"
+	}
+	log.Printf("API: job %s of run %d is %s by %s", d.Job, id, verb, by)
+	writeJSON(w, http.StatusOK, approval)
+}
+
+// approver returns the name of the approver whose token authenticates r,
+// empty when there is none.
+func (a *API) approver(r *http.Request) string {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if bearer == "" {
+		return ""
+	}
+	for name, token := range a.Approvers {
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+// ReadApproverTokens reads approvers and their tokens from file, one name=token
+// per line. Empty lines and lines starting with # are ignored.
+func ReadApproverTokens(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		j := strings.Index(line, "=")
+		if j <= 0 || strings.TrimSpace(line[j+1:]) == "" {
+			return nil, fmt.Errorf("line %d: expected format is name=token", i+1)
+		}
+		tokens[strings.TrimSpace(line[:j])] = strings.TrimSpace(line[j+1:])
+	}
+	return tokens, nil
+}
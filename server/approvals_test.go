@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/locngoxuan/vulcan/history"
+	"github.com/locngoxuan/vulcan/runner"
+)
+
+func TestDecisionIsTakenByApproverOfToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api := NewAPI(ctx, "shared", nil, runner.Runner{}, nil)
+	api.Approvers = map[string]string{"alice": "alice-token", "eve": "eve-token"}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	decided := make(chan history.Approval, 1)
+	go func() {
+		a, err := api.Approve(ctx, runner.ApprovalRequest{
+			RunId:     7,
+			Job:       "prod",
+			Approvers: []string{"alice"},
+			Requested: time.Now(),
+		})
+		if err == nil {
+			decided <- a
+		}
+	}()
+	for i := 0; len(api.pending(7)) == 0; i++ {
+		if i > 100 {
+			t.Fatal("job is not waiting for approval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"shared", http.StatusForbidden},
+		{"eve-token", http.StatusForbidden},
+		{"alice-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		// a name claimed in the body is not trusted
+		body := strings.NewReader(`{"job":"prod","by":"alice","approved":true}`)
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/runs/7/approvals", body)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("token %q: got status %d, want %d", tt.token, resp.StatusCode, tt.status)
+		}
+	}
+
+	select {
+	case a := <-decided:
+		if !a.Approved || a.By != "alice" {
+			t.Fatalf("got decision %+v, want approved by alice", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job is not decided")
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Groups serializes runs and jobs of the same concurrency group in server.
+// Those waiting for a group get it in order of arrival.
+type Groups struct {
+	mu     sync.Mutex
+	groups map[string]*groupQueue
+}
+
+type groupQueue struct {
+	holder  *groupEntry
+	waiting []*groupEntry
+}
+
+type groupEntry struct {
+	ready  chan struct{}
+	cancel context.CancelFunc
+}
+
+// NewGroups returns groups where nothing is locked.
+func NewGroups() *Groups {
+	return &Groups{groups: make(map[string]*groupQueue)}
+}
+
+// Lock implements runner.Locker. With cancelInProgress, the holder of group
+// and those waiting for it are cancelled.
+func (g *Groups) Lock(ctx context.Context, l *log.Logger, group string, cancelInProgress bool) (context.Context, func(), error) {
+	lctx, cancel := context.WithCancel(ctx)
+	e := &groupEntry{
+		ready:  make(chan struct{}),
+		cancel: cancel,
+	}
+	g.mu.Lock()
+	q, ok := g.groups[group]
+	if !ok {
+		q = &groupQueue{}
+		g.groups[group] = q
+	}
+	if cancelInProgress && (q.holder != nil || len(q.waiting) > 0) {
+		l.Printf("cancelling concurrency group %s in progress", group)
+		if q.holder != nil {
+			q.holder.cancel()
+		}
+		for _, w := range q.waiting {
+			w.cancel()
+		}
+	}
+	if q.holder == nil {
+		q.holder = e
+		close(e.ready)
+	} else {
+		q.waiting = append(q.waiting, e)
+		if !cancelInProgress {
+			l.Printf("waiting for concurrency group %s", group)
+		}
+	}
+	g.mu.Unlock()
+
+	unlock := func() {
+		g.release(group, e)
+		cancel()
+	}
+	select {
+	case <-e.ready:
+		return lctx, unlock, nil
+	case <-lctx.Done():
+		unlock()
+		return nil, nil, lctx.Err()
+	}
+}
+
+// release frees group held by e, or stops e waiting for it.
+func (g *Groups) release(group string, e *groupEntry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	q, ok := g.groups[group]
+	if !ok {
+		return
+	}
+	if q.holder == e {
+		q.holder = nil
+		if len(q.waiting) > 0 {
+			q.holder = q.waiting[0]
+			q.waiting = q.waiting[1:]
+			close(q.holder.ready)
+		}
+	} else {
+		for i, w := range q.waiting {
+			if w == e {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	if q.holder == nil && len(q.waiting) == 0 {
+		delete(g.groups, group)
+	}
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var web embed.FS
+
+// Dashboard serves the web dashboard. It lists projects and runs, and follows
+// a run with the API, so the token of API is asked by the page itself.
+func Dashboard() http.Handler {
+	root, err := fs.Sub(web, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
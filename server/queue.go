@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("queue is full")
+
+// Queue runs events by a fixed number of workers, in order of arrival.
+type Queue struct {
+	events chan Event
+	wg     sync.WaitGroup
+}
+
+// NewQueue starts workers running events with run until ctx is done or queue
+// is closed. At most size events wait for a worker.
+func NewQueue(ctx context.Context, size, workers int, run func(context.Context, Event)) *Queue {
+	q := &Queue{events: make(chan Event, size)}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e, ok := <-q.events:
+					if !ok {
+						return
+					}
+					run(ctx, e)
+				}
+			}
+		}()
+	}
+	return q
+}
+
+// Enqueue adds event to queue without waiting, ErrQueueFull is returned when
+// there is no room left.
+func (q *Queue) Enqueue(e Event) error {
+	select {
+	case q.events <- e:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting events and waits for workers to finish the queued
+// ones.
+func (q *Queue) Close() {
+	close(q.events)
+	q.wg.Wait()
+}
@@ -0,0 +1,386 @@
+'use strict';
+
+const state = {
+  token: localStorage.getItem('vulcan-token') || '',
+  projects: [],
+  run: null,
+  pending: '',
+  stream: null,
+  timer: null,
+};
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k === 'class') {
+      e.className = v;
+    } else if (k.startsWith('on')) {
+      e.addEventListener(k.slice(2), v);
+    } else {
+      e.setAttribute(k, v);
+    }
+  }
+  for (const c of children) {
+    e.append(c);
+  }
+  return e;
+}
+
+function showError(err) {
+  const p = document.getElementById('error');
+  p.textContent = err ? String(err) : '';
+  p.hidden = !err;
+}
+
+async function api(method, path, body) {
+  const headers = {};
+  if (state.token) {
+    headers['Authorization'] = 'Bearer ' + state.token;
+  }
+  if (body !== undefined) {
+    headers['Content-Type'] = 'application/json';
+  }
+  const resp = await fetch(path, {
+    method: method,
+    headers: headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+function statusBadge(status) {
+  return el('span', {class: 'status ' + status}, status);
+}
+
+function duration(start, end) {
+  const s = Date.parse(start);
+  let e = Date.parse(end);
+  if (!s || s < 0) {
+    return '-';
+  }
+  if (!e || e < s) {
+    e = Date.now();
+  }
+  const secs = Math.round((e - s) / 1000);
+  if (secs < 60) {
+    return secs + 's';
+  }
+  if (secs < 3600) {
+    return Math.floor(secs / 60) + 'm' + (secs % 60) + 's';
+  }
+  return Math.floor(secs / 3600) + 'h' + Math.floor((secs % 3600) / 60) + 'm';
+}
+
+function projectOf(dir) {
+  return state.projects.find(p => p.dir === dir);
+}
+
+async function loadProjects() {
+  state.projects = await api('GET', '/projects');
+  const ul = document.getElementById('projects');
+  ul.replaceChildren(el('li', {}, el('a', {href: '#'}, 'All projects')));
+  for (const p of state.projects) {
+    const actions = el('div');
+    for (const action of p.actions) {
+      actions.append(el('button', {onclick: () => startRun({project: p.name, action: action})}, 'Run ' + action), ' ');
+    }
+    ul.append(el('li', {},
+      el('a', {href: '#project/' + encodeURIComponent(p.name)}, p.name),
+      el('div', {class: 'dir'}, p.dir),
+      actions));
+  }
+}
+
+async function loadRuns(project) {
+  let path = '/runs?limit=50';
+  if (project) {
+    path += '&project=' + encodeURIComponent(project);
+  }
+  const runs = await api('GET', path);
+  document.getElementById('runs-title').textContent = project ? 'Recent runs of ' + project : 'Recent runs';
+  const tbody = document.getElementById('runs');
+  tbody.replaceChildren();
+  for (const run of runs) {
+    tbody.append(el('tr', {},
+      el('td', {}, el('a', {href: '#run/' + run.id}, '#' + run.id)),
+      el('td', {}, run.action),
+      el('td', {}, run.job || '*'),
+      el('td', {}, run.trigger),
+      el('td', {}, statusBadge(run.status)),
+      el('td', {}, new Date(run.start).toLocaleString()),
+      el('td', {}, duration(run.start, run.end))));
+  }
+}
+
+function renderTimeline(job) {
+  const steps = job.steps || [];
+  const start = Date.parse(job.start);
+  let end = Date.parse(job.end);
+  if (!end || end < start) {
+    end = Date.now();
+  }
+  const total = Math.max(end - start, 1);
+  const timeline = el('div', {class: 'timeline'});
+  for (const step of steps) {
+    const s = Date.parse(step.start);
+    let e = Date.parse(step.end);
+    if (!e || e < s) {
+      e = end;
+    }
+    const left = Math.max(0, (s - start) / total * 100);
+    const width = Math.max(0, (e - s) / total * 100);
+    const bar = el('div', {class: 'bar ' + step.status});
+    bar.style.left = left + '%';
+    bar.style.width = width + '%';
+    let name = step.name || step.id || '';
+    if (step.attempts > 1) {
+      name += ' (' + step.attempts + ' attempts)';
+    }
+    timeline.append(el('div', {class: 'step', title: (step.commands || []).join('\n')},
+      el('span', {class: 'name'}, name),
+      el('span', {class: 'track'}, bar),
+      el('span', {class: 'duration'}, duration(step.start, step.end))));
+  }
+  return timeline;
+}
+
+function renderRun(run) {
+  document.getElementById('run-title').textContent = 'Run #' + run.id + ' · ' + run.action + (run.job ? ' · ' + run.job : '');
+  const summary = document.getElementById('run-summary');
+  summary.replaceChildren(statusBadge(run.status),
+    ' ' + run.trigger + ' · started ' + new Date(run.start).toLocaleString() + ' · ' + duration(run.start, run.end));
+  if (run.error) {
+    summary.append(el('div', {class: 'error'}, run.error));
+  }
+  document.getElementById('cancel').disabled = run.status !== 'running';
+  const rerun = document.getElementById('rerun');
+  rerun.disabled = !projectOf(run.project);
+  rerun.title = rerun.disabled ? 'project ' + run.project + ' is not registered' : '';
+
+  const jobs = document.getElementById('jobs');
+  jobs.replaceChildren();
+  const select = document.getElementById('log-job');
+  const selected = select.value;
+  select.replaceChildren(el('option', {value: ''}, 'all jobs'));
+  for (const job of run.jobs || []) {
+    const approval = job.approval && job.approval.approved ? el('div', {}, 'Approved by ' + job.approval.by) : '';
+    jobs.append(el('div', {class: 'job'},
+      el('h3', {}, job.id + ' ', statusBadge(job.status),
+        ' exit code ' + job.exit_code + ' · ' + duration(job.start, job.end)),
+      approval,
+      job.host ? el('div', {class: 'image'}, 'Host ' + job.host) : '',
+      job.digest ? el('div', {class: 'image', title: job.digest}, 'Image ' + job.image + ' · ' + job.digest) : '',
+      job.error ? el('div', {class: 'error'}, job.error) : '',
+      renderTimeline(job)));
+    select.append(el('option', {value: job.id}, job.id));
+  }
+  select.value = selected;
+}
+
+// loadApprovals shows jobs of run waiting for approval. They are only rendered
+// again when they change, so that a decision being typed is kept.
+async function loadApprovals(run) {
+  const pending = run.status === 'running' ? await api('GET', '/runs/' + run.id + '/approvals') : [];
+  const key = pending.map(req => req.run_id + '/' + req.job).join(',');
+  if (key === state.pending) {
+    return;
+  }
+  state.pending = key;
+  const div = document.getElementById('approvals');
+  div.replaceChildren();
+  for (const req of pending) {
+    const comment = el('input', {placeholder: 'comment'});
+    const decide = (approved) => async () => {
+      try {
+        await api('POST', '/runs/' + run.id + '/approvals', {job: req.job, approved: approved, comment: comment.value});
+        await refreshRun(run.id);
+      } catch (err) {
+        showError(err);
+      }
+    };
+    let text = 'Job ' + req.job + ' is waiting for approval until ' + new Date(req.deadline).toLocaleString();
+    if (req.approvers) {
+      text += ' (approvers: ' + req.approvers.join(', ') + ')';
+    }
+    div.append(el('div', {class: 'approval'},
+      el('p', {}, text),
+      comment,
+      el('button', {onclick: decide(true)}, 'Approve'), ' ',
+      el('button', {onclick: decide(false)}, 'Reject')));
+  }
+}
+
+function stopFollowing() {
+  if (state.stream) {
+    state.stream.abort();
+    state.stream = null;
+  }
+  if (state.timer) {
+    clearTimeout(state.timer);
+    state.timer = null;
+  }
+}
+
+async function followLog(id) {
+  const job = document.getElementById('log-job').value;
+  const pre = document.getElementById('log');
+  pre.textContent = '';
+  const controller = new AbortController();
+  state.stream = controller;
+  const headers = {};
+  if (state.token) {
+    headers['Authorization'] = 'Bearer ' + state.token;
+  }
+  try {
+    const resp = await fetch('/runs/' + id + '/logs' + (job ? '?job=' + encodeURIComponent(job) : ''), {
+      headers: headers,
+      signal: controller.signal,
+    });
+    if (!resp.ok) {
+      throw new Error((await resp.json().catch(() => ({}))).error || resp.statusText);
+    }
+    const reader = resp.body.getReader();
+    const decoder = new TextDecoder();
+    for (;;) {
+      const {value, done} = await reader.read();
+      if (done) {
+        break;
+      }
+      const follow = pre.scrollTop + pre.clientHeight >= pre.scrollHeight - 4;
+      pre.textContent += decoder.decode(value, {stream: true});
+      if (follow) {
+        pre.scrollTop = pre.scrollHeight;
+      }
+    }
+  } catch (err) {
+    if (err.name !== 'AbortError') {
+      showError(err);
+    }
+  }
+}
+
+async function refreshRun(id) {
+  clearTimeout(state.timer);
+  const run = await api('GET', '/runs/' + id);
+  if (!state.run || state.run.id !== id) {
+    return;
+  }
+  state.run = run;
+  renderRun(run);
+  await loadApprovals(run);
+  if (run.status === 'running') {
+    state.timer = setTimeout(() => refreshRun(id).catch(showError), 3000);
+  }
+}
+
+async function showRun(id) {
+  const run = await api('GET', '/runs/' + id);
+  state.run = run;
+  state.pending = '';
+  document.getElementById('log-job').value = '';
+  document.getElementById('approvals').replaceChildren();
+  renderRun(run);
+  await loadApprovals(run);
+  followLog(id);
+  if (run.status === 'running') {
+    state.timer = setTimeout(() => refreshRun(id).catch(showError), 3000);
+  }
+}
+
+async function startRun(req) {
+  try {
+    const run = await api('POST', '/runs', req);
+    location.hash = '#run/' + run.id;
+  } catch (err) {
+    showError(err);
+  }
+}
+
+async function route() {
+  stopFollowing();
+  showError(null);
+  state.run = null;
+  const hash = decodeURIComponent(location.hash.slice(1));
+  const runView = hash.startsWith('run/');
+  document.getElementById('runs-view').hidden = runView;
+  document.getElementById('run-view').hidden = !runView;
+  try {
+    if (runView) {
+      await showRun(Number(hash.slice(4)));
+    } else {
+      await loadRuns(hash.startsWith('project/') ? hash.slice(8) : '');
+    }
+  } catch (err) {
+    showError(err);
+  }
+}
+
+document.getElementById('rerun').addEventListener('click', () => {
+  const run = state.run;
+  const project = run && projectOf(run.project);
+  if (!project) {
+    return;
+  }
+  const env = {};
+  for (const kv of run.trigger_env || []) {
+    const i = kv.indexOf('=');
+    if (i > 0) {
+      env[kv.slice(0, i)] = kv.slice(i + 1);
+    }
+  }
+  startRun({project: project.name, action: run.action, job: run.job, env: env});
+});
+
+document.getElementById('cancel').addEventListener('click', async () => {
+  if (!state.run) {
+    return;
+  }
+  try {
+    await api('DELETE', '/runs/' + state.run.id);
+    await refreshRun(state.run.id);
+  } catch (err) {
+    showError(err);
+  }
+});
+
+document.getElementById('log-job').addEventListener('change', () => {
+  if (state.run) {
+    if (state.stream) {
+      state.stream.abort();
+    }
+    followLog(state.run.id);
+  }
+});
+
+document.getElementById('token').value = state.token;
+document.getElementById('token-form').addEventListener('submit', (e) => {
+  e.preventDefault();
+  state.token = document.getElementById('token').value;
+  localStorage.setItem('vulcan-token', state.token);
+  init();
+});
+
+window.addEventListener('hashchange', route);
+
+async function init() {
+  try {
+    await loadProjects();
+  } catch (err) {
+    showError(err);
+    return;
+  }
+  route();
+}
+
+setInterval(() => {
+  if (!state.run && !document.getElementById('runs-view').hidden) {
+    const hash = decodeURIComponent(location.hash.slice(1));
+    loadRuns(hash.startsWith('project/') ? hash.slice(8) : '').catch(showError);
+  }
+}, 5000);
+
+init();
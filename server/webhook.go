@@ -0,0 +1,292 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/locngoxuan/vulcan/core"
+	"github.com/locngoxuan/vulcan/scm"
+)
+
+const (
+	ForgeGitHub = "github"
+	ForgeGitea  = "gitea"
+	ForgeGitLab = "gitlab"
+)
+
+// maxPayloadSize limits size of webhook payloads read in memory.
+const maxPayloadSize = 10 << 20
+
+const nullCommit = "0000000000000000000000000000000000000000"
+
+// Event is a change of a repository received from a forge.
+type Event struct {
+	Forge string
+	// Repository is the full name of repository, e.g. owner/name.
+	Repository string
+	CloneURL   string
+	Trigger    core.Trigger
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s: %s", e.Forge, e.Repository, e.Trigger)
+}
+
+// errIgnored is returned for payloads which never trigger an action, such as
+// ping or deleted branches.
+var errIgnored = errors.New("event is ignored")
+
+// WebhookHandler receives push, tag and pull request webhooks of GitHub, Gitea
+// and GitLab, then passes them to Enqueue. An error of Enqueue rejects the
+// event. Payloads of GitHub and Gitea must be
+// signed by HMAC-SHA256 with Secret, GitLab must send Secret as token. Without
+// Secret, payloads are rejected unless Insecure is set.
+type WebhookHandler struct {
+	Secret   string
+	Insecure bool
+	Enqueue  func(Event) error
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, "failed to read payload", http.StatusBadRequest)
+		return
+	}
+
+	forge := detectForge(r.Header)
+	if forge == "" {
+		http.Error(w, "unknown webhook", http.StatusBadRequest)
+		return
+	}
+	if !h.verify(forge, r.Header, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := ParseWebhook(forge, r.Header, body)
+	if err == errIgnored {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"queued": false})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.Enqueue(event)
+	if err != nil {
+		log.Printf("Webhook: %s not queued: %v", event, err)
+		status := http.StatusUnprocessableEntity
+		if err == ErrQueueFull {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log.Printf("Webhook: %s queued", event)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"queued": true})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// authorized tells if request carries token as bearer. An empty token
+// authorizes no request.
+func authorized(token string, r *http.Request) bool {
+	if token == "" {
+		return false
+	}
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+// detectForge tells the forge sending webhook from its headers. Gitea sends
+// headers of GitHub as well, so it is checked first.
+func detectForge(header http.Header) string {
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		return ForgeGitea
+	case header.Get("X-GitHub-Event") != "":
+		return ForgeGitHub
+	case header.Get("X-Gitlab-Event") != "":
+		return ForgeGitLab
+	}
+	return ""
+}
+
+func (h *WebhookHandler) verify(forge string, header http.Header, body []byte) bool {
+	if h.Secret == "" {
+		return h.Insecure
+	}
+	var signature string
+	switch forge {
+	case ForgeGitLab:
+		token := header.Get("X-Gitlab-Token")
+		return subtle.ConstantTimeCompare([]byte(token), []byte(h.Secret)) == 1
+	case ForgeGitea:
+		signature = header.Get("X-Gitea-Signature")
+	case ForgeGitHub:
+		signature = strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+type repository struct {
+	FullName          string `json:"full_name"`
+	CloneURL          string `json:"clone_url"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	GitHTTPURL        string `json:"git_http_url"`
+}
+
+type githubPush struct {
+	Ref        string     `json:"ref"`
+	After      string     `json:"after"`
+	Deleted    bool       `json:"deleted"`
+	Repository repository `json:"repository"`
+}
+
+type githubPullRequest struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Head struct {
+			Sha string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository repository `json:"repository"`
+}
+
+type gitlabPush struct {
+	ObjectKind  string     `json:"object_kind"`
+	Ref         string     `json:"ref"`
+	After       string     `json:"after"`
+	CheckoutSha string     `json:"checkout_sha"`
+	Project     repository `json:"project"`
+}
+
+type gitlabMergeRequest struct {
+	ObjectKind       string `json:"object_kind"`
+	ObjectAttributes struct {
+		Iid          int    `json:"iid"`
+		Action       string `json:"action"`
+		TargetBranch string `json:"target_branch"`
+		LastCommit   struct {
+			Id string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+	Project repository `json:"project"`
+}
+
+// ParseWebhook returns event of a webhook payload sent by forge.
+func ParseWebhook(forge string, header http.Header, body []byte) (Event, error) {
+	event := Event{Forge: forge}
+	switch forge {
+	case ForgeGitHub, ForgeGitea:
+		kind := header.Get("X-GitHub-Event")
+		if forge == ForgeGitea {
+			kind = header.Get("X-Gitea-Event")
+		}
+		switch kind {
+		case "push":
+			var p githubPush
+			if err := json.Unmarshal(body, &p); err != nil {
+				return event, fmt.Errorf("invalid payload: %v", err)
+			}
+			if p.Deleted || p.After == "" || p.After == nullCommit {
+				return event, errIgnored
+			}
+			event.Repository, event.CloneURL = p.Repository.FullName, p.Repository.CloneURL
+			return event, pushTrigger(&event, p.Ref, p.After)
+		case "pull_request":
+			var p githubPullRequest
+			if err := json.Unmarshal(body, &p); err != nil {
+				return event, fmt.Errorf("invalid payload: %v", err)
+			}
+			switch p.Action {
+			case "opened", "reopened", "synchronize", "synchronized":
+			default:
+				return event, errIgnored
+			}
+			event.Repository, event.CloneURL = p.Repository.FullName, p.Repository.CloneURL
+			event.Trigger = pullRequestTrigger("refs/pull/%d/head", p.Number, p.PullRequest.Head.Sha, p.PullRequest.Base.Ref)
+			return event, nil
+		}
+	case ForgeGitLab:
+		switch header.Get("X-Gitlab-Event") {
+		case "Push Hook", "Tag Push Hook":
+			var p gitlabPush
+			if err := json.Unmarshal(body, &p); err != nil {
+				return event, fmt.Errorf("invalid payload: %v", err)
+			}
+			commit := p.CheckoutSha
+			if commit == "" {
+				commit = p.After
+			}
+			if commit == "" || commit == nullCommit {
+				return event, errIgnored
+			}
+			event.Repository, event.CloneURL = p.Project.PathWithNamespace, p.Project.GitHTTPURL
+			return event, pushTrigger(&event, p.Ref, commit)
+		case "Merge Request Hook":
+			var p gitlabMergeRequest
+			if err := json.Unmarshal(body, &p); err != nil {
+				return event, fmt.Errorf("invalid payload: %v", err)
+			}
+			a := p.ObjectAttributes
+			switch a.Action {
+			case "open", "reopen", "update":
+			default:
+				return event, errIgnored
+			}
+			event.Repository, event.CloneURL = p.Project.PathWithNamespace, p.Project.GitHTTPURL
+			event.Trigger = pullRequestTrigger("refs/merge-requests/%d/head", a.Iid, a.LastCommit.Id, a.TargetBranch)
+			return event, nil
+		}
+	}
+	return event, errIgnored
+}
+
+func pushTrigger(event *Event, ref, commit string) error {
+	t, ok := scm.NewTrigger(ref, commit, "")
+	if !ok || t.Event == core.EventPullRequest {
+		return fmt.Errorf("unsupported ref %s", ref)
+	}
+	event.Trigger = t
+	return nil
+}
+
+func pullRequestTrigger(refFormat string, number int, commit, base string) core.Trigger {
+	return core.Trigger{
+		Event:       core.EventPullRequest,
+		Ref:         fmt.Sprintf(refFormat, number),
+		Commit:      commit,
+		Branch:      base,
+		PullRequest: fmt.Sprintf("%d", number),
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/locngoxuan/vulcan/core"
+)
+
+const testSecret = "webhook-secret"
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signed returns headers of forge sending event, signed by secret.
+func signed(forge, event, secret string, body []byte) http.Header {
+	h := http.Header{}
+	switch forge {
+	case ForgeGitHub:
+		h.Set("X-GitHub-Event", event)
+		h.Set("X-Hub-Signature-256", "sha256="+sign(secret, body))
+	case ForgeGitea:
+		h.Set("X-GitHub-Event", event)
+		h.Set("X-Gitea-Event", event)
+		h.Set("X-Gitea-Signature", sign(secret, body))
+	case ForgeGitLab:
+		h.Set("X-Gitlab-Event", event)
+		h.Set("X-Gitlab-Token", secret)
+	}
+	return h
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		forge   string
+		event   string
+		payload string
+		secret  string
+		status  int
+		want    *Event
+	}{
+		{
+			name: "github push", forge: ForgeGitHub, event: "push", payload: "github-push.json",
+			secret: testSecret, status: http.StatusAccepted,
+			want: &Event{Forge: ForgeGitHub, Repository: "owner/project", CloneURL: "https://github.com/owner/project.git",
+				Trigger: core.Trigger{Event: core.EventPush, Ref: "refs/heads/main", Branch: "main", Commit: "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c"}},
+		},
+		{
+			name: "github deleted branch", forge: ForgeGitHub, event: "push", payload: "github-delete.json",
+			secret: testSecret, status: http.StatusOK,
+		},
+		{
+			name: "github pull request", forge: ForgeGitHub, event: "pull_request", payload: "github-pull-request.json",
+			secret: testSecret, status: http.StatusAccepted,
+			want: &Event{Forge: ForgeGitHub, Repository: "owner/project", CloneURL: "https://github.com/owner/project.git",
+				Trigger: core.Trigger{Event: core.EventPullRequest, Ref: "refs/pull/42/head", Branch: "main", PullRequest: "42", Commit: "34c5c7793cb3b279e22454cb6750c80560547b3a"}},
+		},
+		{
+			name: "github wrong secret", forge: ForgeGitHub, event: "push", payload: "github-push.json",
+			secret: "guess", status: http.StatusUnauthorized,
+		},
+		{
+			name: "gitea tag", forge: ForgeGitea, event: "push", payload: "gitea-tag.json",
+			secret: testSecret, status: http.StatusAccepted,
+			want: &Event{Forge: ForgeGitea, Repository: "owner/project", CloneURL: "https://gitea.example.com/owner/project.git",
+				Trigger: core.Trigger{Event: core.EventTag, Ref: "refs/tags/v1.2.0", Tag: "v1.2.0", Commit: "28e1879d029cb852e4844d9c718537df08844e03"}},
+		},
+		{
+			name: "gitea wrong secret", forge: ForgeGitea, event: "push", payload: "gitea-tag.json",
+			secret: "guess", status: http.StatusUnauthorized,
+		},
+		{
+			name: "gitlab push", forge: ForgeGitLab, event: "Push Hook", payload: "gitlab-push.json",
+			secret: testSecret, status: http.StatusAccepted,
+			want: &Event{Forge: ForgeGitLab, Repository: "group/project", CloneURL: "https://gitlab.example.com/group/project.git",
+				Trigger: core.Trigger{Event: core.EventPush, Ref: "refs/heads/main", Branch: "main", Commit: "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"}},
+		},
+		{
+			name: "gitlab merge request", forge: ForgeGitLab, event: "Merge Request Hook", payload: "gitlab-merge-request.json",
+			secret: testSecret, status: http.StatusAccepted,
+			want: &Event{Forge: ForgeGitLab, Repository: "group/project", CloneURL: "https://gitlab.example.com/group/project.git",
+				Trigger: core.Trigger{Event: core.EventPullRequest, Ref: "refs/merge-requests/7/head", Branch: "main", PullRequest: "7", Commit: "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"}},
+		},
+		{
+			name: "gitlab wrong token", forge: ForgeGitLab, event: "Push Hook", payload: "gitlab-push.json",
+			secret: "guess", status: http.StatusUnauthorized,
+		},
+		{
+			name: "gitlab missing token", forge: ForgeGitLab, event: "Push Hook", payload: "gitlab-push.json",
+			secret: "", status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := ioutil.ReadFile(filepath.Join("testdata", "webhooks", tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			queued := make(chan Event, 1)
+			srv := httptest.NewServer(&WebhookHandler{
+				Secret: testSecret,
+				Enqueue: func(e Event) error {
+					queued <- e
+					return nil
+				},
+			})
+			defer srv.Close()
+
+			status := post(t, srv.URL, signed(tt.forge, tt.event, tt.secret, body), body)
+			if status != tt.status {
+				t.Fatalf("got status %d, want %d", status, tt.status)
+			}
+			var got *Event
+			select {
+			case e := <-queued:
+				got = &e
+			default:
+			}
+			switch {
+			case tt.want == nil && got != nil:
+				t.Fatalf("event %v must not be queued", got)
+			case tt.want != nil && got == nil:
+				t.Fatalf("event is not queued")
+			case tt.want != nil && *got != *tt.want:
+				t.Fatalf("got event %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "webhooks", "github-push.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, insecure := range []bool{false, true} {
+		queued := make(chan Event, 1)
+		srv := httptest.NewServer(&WebhookHandler{
+			Insecure: insecure,
+			Enqueue: func(e Event) error {
+				queued <- e
+				return nil
+			},
+		})
+		header := http.Header{}
+		header.Set("X-GitHub-Event", "push")
+		status := post(t, srv.URL, header, body)
+		srv.Close()
+		if accepted := len(queued) > 0; accepted != insecure {
+			t.Errorf("insecure %v: unsigned payload queued %v, status %d", insecure, accepted, status)
+		}
+	}
+}
+
+func TestWebhookRejectedByEnqueue(t *testing.T) {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "webhooks", "github-push.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&WebhookHandler{
+		Secret: testSecret,
+		Enqueue: func(e Event) error {
+			return fmt.Errorf("repository %s is not registered", e.Repository)
+		},
+	})
+	defer srv.Close()
+	status := post(t, srv.URL, signed(ForgeGitHub, "push", testSecret, body), body)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+}
+
+func post(t *testing.T, url string, header http.Header, body []byte) int {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}